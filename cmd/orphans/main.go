@@ -0,0 +1,35 @@
+/*
+Copyright 2020 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"k8c.io/utils/pkg/orphans"
+	"k8c.io/utils/pkg/util"
+)
+
+func main() {
+	cmd := orphans.NewFlags().NewCommand(ctrl.Log, "orphans")
+	cmd = util.CmdLogMixin(cmd)
+	if err := cmd.Execute(); err != nil {
+		ctrl.Log.Error(err, "error during execution")
+		os.Exit(2)
+	}
+}
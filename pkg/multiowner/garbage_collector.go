@@ -0,0 +1,199 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multiowner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"k8c.io/utils/pkg/util"
+)
+
+var (
+	gcPrunedReferences = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "multiowner_garbage_collector_pruned_references_total",
+		Help: "Number of references to deleted owners removed from owned objects.",
+	}, []string{"owner", "object"})
+	gcDeletedObjects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "multiowner_garbage_collector_deleted_objects_total",
+		Help: "Number of objects deleted, because their last owner was deleted.",
+	}, []string{"owner", "object"})
+)
+
+// registerMetrics registers the GarbageCollector metrics with registry, metrics already registered are kept.
+func registerMetrics(registry prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{gcPrunedReferences, gcDeletedObjects} {
+		if err := registry.Register(c); err != nil {
+			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+				return fmt.Errorf("registering metrics: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// GarbageCollector removes references to deleted owners from the owner annotation of owned objects,
+// and deletes objects once their last owner is gone.
+//
+// Owned objects are found with ListOwnedObjects, register the owner reverse field index
+// for all OwnedTypes to not list all objects, see AddOwnerReverseFieldIndex.
+// Pruned references and deleted objects are counted in the
+// multiowner_garbage_collector_pruned_references_total and multiowner_garbage_collector_deleted_objects_total metrics,
+// which are registered by SetupWithManager.
+//
+// Owners are only resolved in the cluster of Client. Entries of owners in another cluster, see WithClusterName,
+// are skipped, as their owners cannot be looked up here.
+type GarbageCollector struct {
+	// Client is used to access owners and owned objects.
+	Client client.Client
+	// APIReader is used to confirm an owner is really gone, before references to it are pruned.
+	// Defaults to Client.
+	APIReader client.Reader
+	Log       logr.Logger
+	Scheme    *runtime.Scheme
+
+	// OwnerType is the type of owners to watch.
+	OwnerType object
+	// OwnedTypes are the types of objects to collect.
+	OwnedTypes []runtime.Object
+	// Options are passed down to DeleteObjectReference.
+	Options []Option
+	// MetricsRegistry is used to register the metrics. Defaults to the controller-runtime metrics.Registry.
+	MetricsRegistry prometheus.Registerer
+}
+
+// SetupWithManager registers the GarbageCollector with the given manager.
+func (gc *GarbageCollector) SetupWithManager(mgr ctrl.Manager) error {
+	if gc.APIReader == nil {
+		gc.APIReader = mgr.GetAPIReader()
+	}
+	if gc.Log == nil {
+		gc.Log = ctrl.Log.WithName("multiowner-garbage-collector")
+	}
+	if gc.MetricsRegistry == nil {
+		gc.MetricsRegistry = metrics.Registry
+	}
+	if err := registerMetrics(gc.MetricsRegistry); err != nil {
+		return err
+	}
+	b, err := util.NewGarbageCollectorBuilder(mgr, gc.Scheme, "multiowner-garbage-collector", gc.OwnerType, gc.OwnedTypes,
+		EnqueueRequestForOwner(gc.OwnerType, gc.Scheme))
+	if err != nil {
+		return err
+	}
+	return b.Complete(gc)
+}
+
+// Reconcile prunes references to the requested owner, if it no longer exists.
+func (gc *GarbageCollector) Reconcile(req reconcile.Request) (reconcile.Result, error) {
+	ctx := context.Background()
+	log := gc.Log.WithValues("owner", req.NamespacedName.String())
+
+	ownerGVK, err := apiutil.GVKForObject(gc.OwnerType, gc.Scheme)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("cannot get GVK for %T: %w", gc.OwnerType, err)
+	}
+	ownerObj, err := util.NewObject(gc.OwnerType, gc.Scheme)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	err = util.GetOwner(ctx, gc.Client, gc.APIReader, req, ownerObj)
+	switch {
+	case err == nil:
+		return reconcile.Result{}, nil
+	case !errors.IsNotFound(err):
+		return reconcile.Result{}, fmt.Errorf("getting owner: %w", err)
+	}
+
+	ownerRef := util.ObjectReference{
+		Name:      req.Name,
+		Namespace: req.Namespace,
+		Group:     ownerGVK.Group,
+		Kind:      ownerGVK.Kind,
+	}
+	objs, err := listOwned(ctx, gc.Client, gc.Scheme, ownerRef, "", gc.OwnedTypes)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	for _, obj := range objs {
+		cluster, err := ownerCluster(obj, ownerRef)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if cluster != "" {
+			// the owner lives in another cluster and is not gone.
+			log.V(6).Info("skipping object owned from another cluster", "object", util.MustLogLine(obj, gc.Scheme), "cluster", cluster)
+			continue
+		}
+
+		changed, deleted, err := removeOwner(ctx, gc.Client, obj, ownerRef, gc.Options)
+		if err != nil {
+			return reconcile.Result{}, fmt.Errorf("pruning owner of %s: %w", util.MustLogLine(obj, gc.Scheme), err)
+		}
+		if !changed {
+			continue
+		}
+
+		objGVK, err := apiutil.GVKForObject(obj, gc.Scheme)
+		if err != nil {
+			return reconcile.Result{}, fmt.Errorf("cannot get GVK for %T: %w", obj, err)
+		}
+		labels := prometheus.Labels{
+			"owner":  ownerGVK.GroupKind().String(),
+			"object": objGVK.GroupKind().String(),
+		}
+		gcPrunedReferences.With(labels).Inc()
+		if deleted {
+			gcDeletedObjects.With(labels).Inc()
+			log.V(6).Info("owned object deleted", "object", util.MustLogLine(obj, gc.Scheme))
+		} else {
+			log.V(6).Info("owner reference pruned", "object", util.MustLogLine(obj, gc.Scheme))
+		}
+	}
+	return reconcile.Result{}, nil
+}
+
+// ownerCluster returns the cluster recorded in the entry of ownerRef on obj.
+func ownerCluster(obj runtime.Object, ownerRef util.ObjectReference) (string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return "", err
+	}
+	entries, err := getEntries(accessor)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.ObjectReference == ownerRef {
+			return entry.Cluster, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multiowner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	prometheustestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"k8c.io/utils/pkg/testutil"
+	"k8c.io/utils/pkg/util"
+)
+
+func TestGarbageCollector(t *testing.T) {
+	sc := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(sc))
+
+	liveOwner := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "live", Namespace: "default"}}
+	goneOwner := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "gone", Namespace: "default"}}
+
+	newConfigMap := func(name string, owners ...*corev1.Secret) *corev1.ConfigMap {
+		cm := &corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "default"}}
+		for _, owner := range owners {
+			_, err := InsertOwnerReference(owner, cm, sc)
+			require.NoError(t, err)
+		}
+		return cm
+	}
+	shared := newConfigMap("shared", liveOwner, goneOwner)
+	orphan := newConfigMap("orphan", goneOwner)
+	owned := newConfigMap("owned", liveOwner)
+	// the owner lives in another cluster, where it still exists.
+	remote := &corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: "remote", Namespace: "default"}}
+	_, err := InsertOwnerReference(goneOwner, remote, sc, WithClusterName("remote"))
+	require.NoError(t, err)
+	// labeled with the remote cluster, but also owned by the local owner.
+	remoteOwner := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "remote-owner", Namespace: "default"}}
+	mixed := newConfigMap("mixed", goneOwner)
+	_, err = InsertOwnerReference(remoteOwner, mixed, sc, WithClusterName("remote"))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	cl := fakeclient.NewFakeClientWithScheme(sc, liveOwner)
+	for _, obj := range []runtime.Object{shared, orphan, owned, remote, mixed} {
+		require.NoError(t, cl.Create(ctx, obj))
+	}
+	gc := &GarbageCollector{
+		Client:     cl,
+		Log:        testutil.NewLogger(t),
+		Scheme:     sc,
+		OwnerType:  &corev1.Secret{},
+		OwnedTypes: []runtime.Object{&corev1.ConfigMap{}},
+	}
+
+	pruned := gcPrunedReferences.WithLabelValues("Secret", "ConfigMap")
+	deleted := gcDeletedObjects.WithLabelValues("Secret", "ConfigMap")
+	prunedBefore, deletedBefore := prometheustestutil.ToFloat64(pruned), prometheustestutil.ToFloat64(deleted)
+
+	for _, owner := range []*corev1.Secret{liveOwner, goneOwner} {
+		_, err := gc.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: owner.Name, Namespace: owner.Namespace}})
+		require.NoError(t, err)
+	}
+
+	getOwners := func(name string) []util.ObjectReference {
+		cm := &corev1.ConfigMap{}
+		require.NoError(t, cl.Get(ctx, client.ObjectKey{Name: name, Namespace: "default"}, cm))
+		refs, err := GetOwnerReferences(cm)
+		require.NoError(t, err)
+		return refs
+	}
+	liveRef := util.ToObjectReference(liveOwner, sc)
+	assert.Equal(t, []util.ObjectReference{liveRef}, getOwners("shared"))
+	assert.Equal(t, []util.ObjectReference{liveRef}, getOwners("owned"))
+	assert.True(t, errors.IsNotFound(cl.Get(ctx, client.ObjectKey{Name: "orphan", Namespace: "default"}, &corev1.ConfigMap{})))
+	assert.Equal(t, []util.ObjectReference{util.ToObjectReference(goneOwner, sc)}, getOwners("remote"))
+	assert.Equal(t, []util.ObjectReference{util.ToObjectReference(remoteOwner, sc)}, getOwners("mixed"))
+
+	assert.Equal(t, 3.0, prometheustestutil.ToFloat64(pruned)-prunedBefore)
+	assert.Equal(t, 1.0, prometheustestutil.ToFloat64(deleted)-deletedBefore)
+
+	// reconciling again is a no-op.
+	_, err = gc.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: goneOwner.Name, Namespace: goneOwner.Namespace}})
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, prometheustestutil.ToFloat64(pruned)-prunedBefore)
+}
+
+func TestRegisterMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registerMetrics(registry))
+	// e.g. multiple garbage collectors for different owner types.
+	require.NoError(t, registerMetrics(registry))
+	assert.True(t, registry.Unregister(gcPrunedReferences))
+	assert.True(t, registry.Unregister(gcDeletedObjects))
+}
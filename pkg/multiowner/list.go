@@ -0,0 +1,179 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multiowner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"k8c.io/utils/pkg/util"
+)
+
+// ListOwnedObjects lists all objects of the given types owned by owner.
+// With WithRole, only objects owned with the given role are listed.
+//
+// The owner index registered by AddOwnerReverseFieldIndex is used if available.
+// Clients without this index, like uncached clients, fall back to listing all objects and filtering them client-side.
+// Results are always filtered client-side, so clients ignoring field selectors, like the fake client, work as well.
+func ListOwnedObjects(ctx context.Context, cl client.Client, scheme *runtime.Scheme, owner object, objectTypes []runtime.Object, opts ...Option) ([]runtime.Object, error) {
+	o := buildOptions(opts)
+	return listOwned(ctx, cl, scheme, util.ToObjectReference(owner, scheme), o.role, objectTypes)
+}
+
+// listOwned lists all objects of the given types owned by ownerRef, with role if it is not empty.
+func listOwned(ctx context.Context, cl client.Client, scheme *runtime.Scheme, ownerRef util.ObjectReference, role Role, objectTypes []runtime.Object) ([]runtime.Object, error) {
+	indexValue := fieldIndexValue(ownerRef)
+	if role != "" {
+		indexValue = roleFieldIndexValue(ownerRef, role)
+	}
+
+	var owned []runtime.Object
+	for _, objectType := range objectTypes {
+		objs, err := util.ListObjects(ctx, cl, scheme, []runtime.Object{objectType}, client.MatchingFields{
+			OwnerAnnotation: indexValue,
+		})
+		if err != nil && isMissingIndex(err) {
+			objs, err = util.ListObjects(ctx, cl, scheme, []runtime.Object{objectType})
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range objs {
+			values, err := ownerIndexValues(obj)
+			if err != nil {
+				// the index skips unparsable objects as well.
+				continue
+			}
+			if containsString(values, indexValue) {
+				owned = append(owned, obj)
+			}
+		}
+	}
+	return owned, nil
+}
+
+// isMissingIndex returns true, if err is caused by a client not supporting the owner field selector.
+// The cache fails for unregistered indexes, and the API server rejects unknown field labels.
+func isMissingIndex(err error) bool {
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		return status.Status().Reason == metav1.StatusReasonBadRequest
+	}
+	return strings.Contains(err.Error(), "does not exist")
+}
+
+// ownerIndexValues returns the values of the owner index for obj.
+func ownerIndexValues(obj runtime.Object) ([]string, error) {
+	accessor, ok := obj.(metav1.Object)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a metav1.Object", obj)
+	}
+	entries, err := getEntries(accessor)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, r := range entries {
+		values = append(values, fieldIndexValue(r.ObjectReference))
+		if r.Role != "" {
+			values = append(values, roleFieldIndexValue(r.ObjectReference, r.Role))
+		}
+	}
+	return values, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// NewIndexingClient wraps cl, to evaluate OwnedBy field selectors client-side.
+//
+// Use it with clients without field index support, like the fake client,
+// so code listing with OwnedBy can be exercised in unit tests.
+// Other field selectors are passed on to cl unchanged.
+func NewIndexingClient(cl client.Client) client.Client {
+	return &indexingClient{Client: cl}
+}
+
+type indexingClient struct {
+	client.Client
+}
+
+func (c *indexingClient) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	if listOpts.FieldSelector == nil {
+		return c.Client.List(ctx, list, opts...)
+	}
+	value, found := listOpts.FieldSelector.RequiresExactMatch(OwnerAnnotation)
+	if !found {
+		return c.Client.List(ctx, list, opts...)
+	}
+
+	// pass on the remaining field requirements.
+	var selectors []fields.Selector
+	for _, r := range listOpts.FieldSelector.Requirements() {
+		if r.Field == OwnerAnnotation {
+			continue
+		}
+		if r.Operator == selection.NotEquals {
+			selectors = append(selectors, fields.OneTermNotEqualSelector(r.Field, r.Value))
+		} else {
+			selectors = append(selectors, fields.OneTermEqualSelector(r.Field, r.Value))
+		}
+	}
+	listOpts.FieldSelector = nil
+	if len(selectors) > 0 {
+		listOpts.FieldSelector = fields.AndSelectors(selectors...)
+	}
+	if err := c.Client.List(ctx, list, listOpts); err != nil {
+		return err
+	}
+
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return fmt.Errorf("extracting list: %w", err)
+	}
+	var filtered []runtime.Object
+	for _, item := range items {
+		values, err := ownerIndexValues(item)
+		if err != nil {
+			continue
+		}
+		if containsString(values, value) {
+			filtered = append(filtered, item)
+		}
+	}
+	return meta.SetList(list, filtered)
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multiowner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"k8c.io/utils/pkg/testutil"
+)
+
+// newFieldSelectorErrorClient fails all lists with a field selector with err.
+func newFieldSelectorErrorClient(cl client.Client, err error) client.Client {
+	return &testutil.InterceptingClient{
+		Client: cl,
+		ListFunc: func(ctx context.Context, cl client.Client, list runtime.Object, opts ...client.ListOption) error {
+			listOpts := &client.ListOptions{}
+			listOpts.ApplyOptions(opts)
+			if listOpts.FieldSelector != nil {
+				return err
+			}
+			return cl.List(ctx, list, opts...)
+		},
+	}
+}
+
+func TestListOwnedObjects(t *testing.T) {
+	sc := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(sc))
+
+	owner := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "owner", Namespace: "default"}}
+	other := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "other", Namespace: "default"}}
+
+	newConfigMap := func(name string, owner *corev1.Secret, opts ...Option) *corev1.ConfigMap {
+		cm := &corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "default"}}
+		if owner != nil {
+			_, err := InsertOwnerReference(owner, cm, sc, opts...)
+			require.NoError(t, err)
+		}
+		return cm
+	}
+	objs := []runtime.Object{
+		newConfigMap("controlled", owner, WithRole(RoleController)),
+		newConfigMap("consumed", owner, WithRole(RoleConsumer)),
+		newConfigMap("owned", owner),
+		newConfigMap("other", other),
+		newConfigMap("unowned", nil),
+	}
+	ctx := context.Background()
+
+	names := func(objs []runtime.Object) []string {
+		var names []string
+		for _, obj := range objs {
+			names = append(names, obj.(*corev1.ConfigMap).Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	for name, cl := range map[string]client.Client{
+		"fake client":     fakeclient.NewFakeClientWithScheme(sc, objs...),
+		"indexing client": NewIndexingClient(fakeclient.NewFakeClientWithScheme(sc, objs...)),
+		"unsupported field selector": newFieldSelectorErrorClient(
+			fakeclient.NewFakeClientWithScheme(sc, objs...),
+			errors.NewBadRequest(`unable to parse requirement: field label not supported: kubermatic.io/owner`),
+		),
+		"missing index": newFieldSelectorErrorClient(
+			fakeclient.NewFakeClientWithScheme(sc, objs...),
+			fmt.Errorf("Index with name field:kubermatic.io/owner does not exist"),
+		),
+	} {
+		t.Run(name, func(t *testing.T) {
+			owned, err := ListOwnedObjects(ctx, cl, sc, owner, []runtime.Object{&corev1.ConfigMap{}})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"consumed", "controlled", "owned"}, names(owned))
+
+			controlled, err := ListOwnedObjects(ctx, cl, sc, owner, []runtime.Object{&corev1.ConfigMap{}}, WithRole(RoleController))
+			require.NoError(t, err)
+			assert.Equal(t, []string{"controlled"}, names(controlled))
+		})
+	}
+
+	t.Run("other errors", func(t *testing.T) {
+		cl := newFieldSelectorErrorClient(
+			fakeclient.NewFakeClientWithScheme(sc, objs...),
+			errors.NewForbidden(corev1.Resource("configmaps"), "", fmt.Errorf("denied")),
+		)
+		_, err := ListOwnedObjects(ctx, cl, sc, owner, []runtime.Object{&corev1.ConfigMap{}})
+		assert.Contains(t, fmt.Sprint(err), "denied")
+	})
+
+	t.Run("indexing client evaluates OwnedBy", func(t *testing.T) {
+		cl := NewIndexingClient(fakeclient.NewFakeClientWithScheme(sc, objs...))
+		list := &corev1.ConfigMapList{}
+		require.NoError(t, cl.List(ctx, list, OwnedBy(owner, sc, WithRole(RoleConsumer)), client.InNamespace("default")))
+		require.Len(t, list.Items, 1)
+		assert.Equal(t, "consumed", list.Items[0].Name)
+
+		list = &corev1.ConfigMapList{}
+		require.NoError(t, cl.List(ctx, list))
+		assert.Len(t, list.Items, len(objs))
+	})
+}
@@ -50,56 +50,127 @@ type object interface {
 }
 
 // InsertOwnerReference adds an OwnerReference to the given object.
-func InsertOwnerReference(owner, object object, scheme *runtime.Scheme) (changed bool, err error) {
+// The entry records the UID of owner, the time it was inserted and the role given by WithRole.
+// For owners already recorded, a missing UID and a changed role are updated.
+// A recreated owner with another UID replaces the UID and timestamp of the previous one,
+// use OwnerRecreated to detect this before.
+//
+// See WithNativeOwnerReference to also set a metav1.OwnerReference,
+// and WithStorage to configure how entries are stored.
+func InsertOwnerReference(owner, object object, scheme *runtime.Scheme, opts ...Option) (changed bool, err error) {
+	o := buildOptions(opts)
 	ownerReference := util.ToObjectReference(owner, scheme)
 
-	refs, err := getRefs(object)
+	if o.clusterName != "" {
+		changed, err = util.SetClusterLabel(object, o.clusterName)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if o.nativeOwnerReference && o.clusterName == "" {
+		nativeChanged, err := util.SetNativeOwnerReference(owner, object, ownerReference, scheme, o.controller, o.blockOwnerDeletion)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || nativeChanged
+	}
+
+	entries, err := getEntries(object)
 	if err != nil {
 		return false, err
 	}
 
-	for _, ref := range refs {
-		if ref == ownerReference {
-			// already inserted, early stop
-			return false, nil
+	for i, entry := range entries {
+		if entry.ObjectReference != ownerReference {
+			continue
+		}
+		updated := updateEntry(&entries[i], owner, o)
+		// already inserted, the entries may still be migrated to another storage.
+		rewritten, err := setEntries(object, entries, o.storage)
+		if err != nil {
+			return false, err
 		}
+		return changed || updated || rewritten, nil
 	}
-	refs = append(refs, ownerReference)
-	err = setRefs(object, refs)
+
+	now := metav1.Now()
+	entries = append(entries, OwnerReference{
+		ObjectReference: ownerReference,
+		UID:             owner.GetUID(),
+		Role:            o.role,
+		Added:           &now,
+		Cluster:         o.clusterName,
+	})
+	_, err = setEntries(object, entries, o.storage)
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
+// updateEntry records the current UID, the role given by WithRole and the cluster given by WithClusterName
+// in an existing entry.
+// Entries of recreated owners are inserted anew.
+func updateEntry(entry *OwnerReference, owner object, o *options) (changed bool) {
+	if uid := owner.GetUID(); uid != "" && entry.UID != uid {
+		if entry.UID != "" {
+			now := metav1.Now()
+			entry.Added = &now
+		}
+		entry.UID = uid
+		changed = true
+	}
+	if o.role != "" && entry.Role != o.role {
+		entry.Role = o.role
+		changed = true
+	}
+	if entry.Cluster != o.clusterName {
+		entry.Cluster = o.clusterName
+		changed = true
+	}
+	return changed
+}
+
 // DeleteOwnerReference removes an owner from the given object.
-func DeleteOwnerReference(owner, object object, scheme *runtime.Scheme) (changed bool, err error) {
-	reference := util.ToObjectReference(owner, scheme)
+func DeleteOwnerReference(owner, object object, scheme *runtime.Scheme, opts ...Option) (changed bool, err error) {
+	return DeleteObjectReference(util.ToObjectReference(owner, scheme), object, opts...)
+}
 
-	refs, err := getRefs(object)
+// DeleteObjectReference removes the referenced owner from the given object.
+//
+// In contrast to DeleteOwnerReference, the owner does not need to exist anymore.
+func DeleteObjectReference(reference util.ObjectReference, object metav1.Object, opts ...Option) (changed bool, err error) {
+	o := buildOptions(opts)
+	if o.nativeOwnerReference && o.clusterName == "" && (reference.Namespace == "" || reference.Namespace == object.GetNamespace()) {
+		changed = util.RemoveNativeOwnerReference(object, reference)
+	}
+
+	entries, err := getEntries(object)
 	if err != nil {
 		return false, err
 	}
 
-	var newRefs []util.ObjectReference
-	for _, ref := range refs {
-		if ref != reference {
-			newRefs = append(newRefs, ref)
+	var newEntries []OwnerReference
+	removed := false
+	for _, entry := range entries {
+		if entry.ObjectReference != reference {
+			newEntries = append(newEntries, entry)
 		} else {
-			changed = true
+			removed = true
 		}
 	}
 
-	// early stopping
-	if !changed {
-		return false, nil
-	}
-
-	err = setRefs(object, newRefs)
+	rewritten, err := setEntries(object, newEntries, o.storage)
 	if err != nil {
 		return false, err
 	}
-	return changed, nil
+	if labels := object.GetLabels(); len(newEntries) == 0 && labels[util.ClusterLabel] != "" {
+		delete(labels, util.ClusterLabel)
+		object.SetLabels(labels)
+		changed = true
+	}
+	return changed || removed || rewritten, nil
 }
 
 // IsOwned checks if any owners claim ownership of this object.
@@ -111,16 +182,23 @@ func IsOwned(object metav1.Object) (owned bool, err error) {
 	return len(refs) > 0, nil
 }
 
+// GetOwnerReferences returns all owners recorded on the given object.
+func GetOwnerReferences(object metav1.Object) ([]util.ObjectReference, error) {
+	return getRefs(object)
+}
+
 // EnqueueRequestForOwner enqueues requests for all owners of an object.
 //
 // It implements the same behavior as handler.EnqueueRequestForOwner, but for our custom objectReference.
-func EnqueueRequestForOwner(ownerType object, scheme *runtime.Scheme) handler.EventHandler {
+// With WithRole, only owners with the given role are enqueued.
+func EnqueueRequestForOwner(ownerType object, scheme *runtime.Scheme, opts ...Option) handler.EventHandler {
+	o := buildOptions(opts)
 	ownerTypeRef := util.ToObjectReference(ownerType, scheme)
 	ownerKind, ownerGroup := ownerTypeRef.Kind, ownerTypeRef.Group
 
 	h := func(obj handler.MapObject) []reconcile.Request {
 		obj.Object.GetObjectKind().GroupVersionKind()
-		refs, err := getRefs(obj.Meta)
+		entries, err := getEntries(obj.Meta)
 		if err != nil {
 			utilruntime.HandleError(
 				fmt.Errorf("parsing owner references name=%s namespace=%s gvk=%s: %w",
@@ -132,8 +210,8 @@ func EnqueueRequestForOwner(ownerType object, scheme *runtime.Scheme) handler.Ev
 			return nil
 		}
 		var req []reconcile.Request
-		for _, r := range refs {
-			if ownerKind == r.Kind && ownerGroup == r.Group {
+		for _, r := range entries {
+			if ownerKind == r.Kind && ownerGroup == r.Group && (o.role == "" || o.role == r.Role) {
 				req = append(req, reconcile.Request{
 					NamespacedName: types.NamespacedName{
 						Namespace: r.Namespace,
@@ -154,6 +232,7 @@ func EnqueueRequestForOwner(ownerType object, scheme *runtime.Scheme) handler.Ev
 //
 // The created index allows listing all owned objects of a given type by the owner object.
 // Keep in mind this function should be called for each owned object type separately.
+// Owners with a role are indexed with and without their role, so OwnedBy can filter by role.
 //
 // See also: OwnedBy
 func AddOwnerReverseFieldIndex(indexer client.FieldIndexer, log logr.Logger, object runtime.Object) error {
@@ -165,29 +244,32 @@ func AddOwnerReverseFieldIndex(indexer client.FieldIndexer, log logr.Logger, obj
 		context.TODO(),
 		object,
 		OwnerAnnotation,
-		func(object runtime.Object) (values []string) {
-			// this should not panic due to previous casting check
-			obj := object.(metav1.Object)
-
-			refs, err := getRefs(obj)
+		func(object runtime.Object) []string {
+			values, err := ownerIndexValues(object)
 			if err != nil {
+				// this should not panic due to previous casting check
+				obj := object.(metav1.Object)
 				log.Error(err, "cannot list owner references", "name", obj.GetName(), "namespace", obj.GetNamespace())
-				return
-			}
-
-			for _, r := range refs {
-				values = append(values, fieldIndexValue(r))
+				return nil
 			}
-			return
+			return values
 		})
 }
 
 // OwnedBy returns owner filter for listing objects.
+// With WithRole, only objects owned with the given role are listed.
 //
-// See also: AddOwnerReverseFieldIndex
-func OwnedBy(owner object, sc *runtime.Scheme) generalizedListOption {
+// See also: AddOwnerReverseFieldIndex, ListOwnedObjects for clients without this index
+func OwnedBy(owner object, sc *runtime.Scheme, opts ...Option) generalizedListOption {
+	o := buildOptions(opts)
+	ref := util.ToObjectReference(owner, sc)
+	if o.role != "" {
+		return client.MatchingFields{
+			OwnerAnnotation: roleFieldIndexValue(ref, o.role),
+		}
+	}
 	return client.MatchingFields{
-		OwnerAnnotation: fieldIndexValue(util.ToObjectReference(owner, sc)),
+		OwnerAnnotation: fieldIndexValue(ref),
 	}
 }
 
@@ -202,34 +284,12 @@ func fieldIndexValue(n util.ObjectReference) string {
 	return string(b)
 }
 
-func getRefs(object metav1.Object) (refs []util.ObjectReference, err error) {
-	annotations := object.GetAnnotations()
-	if annotations == nil {
-		return nil, nil
-	}
-
-	if data, present := annotations[OwnerAnnotation]; present {
-		err = json.Unmarshal([]byte(data), &refs)
-		return
-	}
-	return
-}
-
-func setRefs(object metav1.Object, refs []util.ObjectReference) error {
-	annotations := object.GetAnnotations()
-	if annotations == nil {
-		annotations = make(map[string]string)
-	}
-	if len(refs) == 0 {
-		delete(annotations, OwnerAnnotation)
-		return nil
-	}
-
-	b, err := json.Marshal(refs)
+// roleFieldIndexValue is like fieldIndexValue, but includes the role of the owner.
+func roleFieldIndexValue(n util.ObjectReference, role Role) string {
+	b, err := json.Marshal(OwnerReference{ObjectReference: n, Role: role})
 	if err != nil {
-		return err
+		// this should never ever happen
+		panic(err)
 	}
-	annotations[OwnerAnnotation] = string(b)
-	object.SetAnnotations(annotations)
-	return nil
+	return string(b)
 }
@@ -18,6 +18,7 @@ package multiowner
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"testing"
 	"time"
@@ -27,11 +28,17 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllertest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"k8c.io/utils/pkg/testutil"
+	"k8c.io/utils/pkg/util"
 )
 
 func TestOwnerReverseFieldIndex(t *testing.T) {
@@ -191,3 +198,278 @@ func TestCRUDOwnerMethods(t *testing.T) {
 		check()
 	}
 }
+
+func TestNativeOwnerReference(t *testing.T) {
+	sc := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(sc))
+
+	ownerA := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "A", Namespace: "default", UID: "a-uid"}}
+	ownerB := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "B", Namespace: "other", UID: "b-uid"}}
+	obj := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "obj", Namespace: "default"}}
+	opt := WithNativeOwnerReference(false, true)
+
+	for _, own := range []*corev1.Pod{ownerA, ownerB} {
+		changed, err := InsertOwnerReference(own, obj, sc, opt)
+		require.NoError(t, err, "insert owner reference")
+		assert.True(t, changed, "obj changed status")
+	}
+	refs, err := GetOwnerReferences(obj)
+	require.NoError(t, err)
+	assert.Len(t, refs, 2)
+	// the owner in the other namespace is only recorded in the annotation.
+	if assert.Len(t, obj.OwnerReferences, 1) {
+		assert.Equal(t, ownerA.UID, obj.OwnerReferences[0].UID)
+		assert.False(t, *obj.OwnerReferences[0].Controller)
+		assert.True(t, *obj.OwnerReferences[0].BlockOwnerDeletion)
+	}
+
+	// enabling the option later adds the native reference to objects, which are already owned.
+	obj.OwnerReferences = nil
+	changed, err := InsertOwnerReference(ownerA, obj, sc, opt)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Len(t, obj.OwnerReferences, 1)
+
+	changed, err = DeleteOwnerReference(ownerA, obj, sc, opt)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Empty(t, obj.OwnerReferences)
+	refs, err = GetOwnerReferences(obj)
+	require.NoError(t, err)
+	assert.Len(t, refs, 1)
+}
+
+func TestClusterName(t *testing.T) {
+	sc := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(sc))
+
+	ownerA := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "A", Namespace: "default"}}
+	ownerB := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "B", Namespace: "default"}}
+	obj := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "obj", Namespace: "default"}}
+	opt := WithClusterName("a")
+
+	for _, own := range []*corev1.Pod{ownerA, ownerB} {
+		changed, err := InsertOwnerReference(own, obj, sc, opt)
+		require.NoError(t, err)
+		assert.True(t, changed)
+	}
+	assert.Equal(t, "a", obj.Labels[util.ClusterLabel])
+
+	_, err := InsertOwnerReference(ownerA, obj, sc, WithClusterName("b"))
+	assert.Error(t, err, "object of another cluster")
+
+	// the label is kept until the last owner is gone.
+	_, err = DeleteOwnerReference(ownerA, obj, sc)
+	require.NoError(t, err)
+	assert.Equal(t, "a", obj.Labels[util.ClusterLabel])
+	_, err = DeleteOwnerReference(ownerB, obj, sc)
+	require.NoError(t, err)
+	assert.NotContains(t, obj.Labels, util.ClusterLabel)
+
+	// native references to owners in another cluster would be garbage collected.
+	ownerWithUID := ownerA.DeepCopy()
+	ownerWithUID.UID = "owner-uid"
+	_, err = InsertOwnerReference(ownerWithUID, obj, sc, opt, WithNativeOwnerReference(false, false))
+	require.NoError(t, err)
+	assert.Empty(t, obj.OwnerReferences)
+}
+
+// funcIndexer captures the extract function of a field index.
+type funcIndexer struct {
+	extract client.IndexerFunc
+}
+
+func (i *funcIndexer) IndexField(_ context.Context, _ runtime.Object, _ string, extract client.IndexerFunc) error {
+	i.extract = extract
+	return nil
+}
+
+func TestOwnerEntries(t *testing.T) {
+	sc := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(sc))
+
+	ownerA := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "A", Namespace: "default", UID: "a-uid"}}
+	ownerB := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "B", Namespace: "default", UID: "b-uid"}}
+	legacy := `[{"name":"A","namespace":"default","group":"","kind":"Pod"}]`
+	obj := &corev1.Pod{ObjectMeta: v1.ObjectMeta{
+		Name:        "obj",
+		Namespace:   "default",
+		Annotations: map[string]string{OwnerAnnotation: legacy},
+	}}
+
+	// entries written by older versions are read without the extra fields.
+	entries, err := GetOwners(obj)
+	require.NoError(t, err)
+	assert.Equal(t, []OwnerReference{{ObjectReference: util.ToObjectReference(ownerA, sc)}}, entries)
+	recreated, err := OwnerRecreated(ownerA, obj, sc)
+	require.NoError(t, err)
+	assert.False(t, recreated)
+
+	// inserting again records the UID and role.
+	changed, err := InsertOwnerReference(ownerA, obj, sc, WithRole(RoleController))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = InsertOwnerReference(ownerB, obj, sc, WithRole(RoleConsumer))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = InsertOwnerReference(ownerB, obj, sc)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	entries, err = GetOwners(obj)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, types.UID("a-uid"), entries[0].UID)
+	assert.Equal(t, RoleController, entries[0].Role)
+	assert.Nil(t, entries[0].Added, "legacy entries have no timestamp")
+	assert.Equal(t, types.UID("b-uid"), entries[1].UID)
+	assert.Equal(t, RoleConsumer, entries[1].Role)
+	assert.NotNil(t, entries[1].Added)
+
+	// the extra fields are ignored by older versions.
+	var refs []util.ObjectReference
+	require.NoError(t, json.Unmarshal([]byte(obj.Annotations[OwnerAnnotation]), &refs))
+	assert.Equal(t, []util.ObjectReference{util.ToObjectReference(ownerA, sc), util.ToObjectReference(ownerB, sc)}, refs)
+
+	recreatedA := ownerA.DeepCopy()
+	recreatedA.UID = "recreated-uid"
+	recreated, err = OwnerRecreated(recreatedA, obj, sc)
+	require.NoError(t, err)
+	assert.True(t, recreated)
+
+	// deleting an owner keeps the entries of the others.
+	changed, err = DeleteOwnerReference(ownerA, obj, sc)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	entries, err = GetOwners(obj)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, RoleConsumer, entries[0].Role)
+
+	t.Run("role filters", func(t *testing.T) {
+		obj := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "obj", Namespace: "default"}}
+		_, err := InsertOwnerReference(ownerA, obj, sc, WithRole(RoleController))
+		require.NoError(t, err)
+		_, err = InsertOwnerReference(ownerB, obj, sc, WithRole(RoleConsumer))
+		require.NoError(t, err)
+
+		h := EnqueueRequestForOwner(&corev1.Pod{}, sc, WithRole(RoleController))
+		q := controllertest.Queue{Interface: workqueue.New()}
+		h.Create(event.CreateEvent{Meta: obj, Object: obj}, q)
+		require.Equal(t, 1, q.Len())
+		item, _ := q.Get()
+		assert.Equal(t, reconcile.Request{NamespacedName: types.NamespacedName{Name: "A", Namespace: "default"}}, item)
+
+		indexer := &funcIndexer{}
+		require.NoError(t, AddOwnerReverseFieldIndex(indexer, testutil.NewLogger(t), &corev1.Pod{}))
+		values := indexer.extract(obj)
+		assert.Contains(t, values, OwnedBy(ownerA, sc).(client.MatchingFields)[OwnerAnnotation])
+		assert.Contains(t, values, OwnedBy(ownerA, sc, WithRole(RoleController)).(client.MatchingFields)[OwnerAnnotation])
+		assert.NotContains(t, values, OwnedBy(ownerA, sc, WithRole(RoleConsumer)).(client.MatchingFields)[OwnerAnnotation])
+		assert.Contains(t, values, OwnedBy(ownerB, sc, WithRole(RoleConsumer)).(client.MatchingFields)[OwnerAnnotation])
+	})
+}
+
+func TestStorage(t *testing.T) {
+	sc := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(sc))
+
+	var owners []*corev1.Pod
+	for i := 0; i < 20; i++ {
+		owners = append(owners, &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: fmt.Sprintf("owner-%02d", i), Namespace: "default"}})
+	}
+	// inserted in reverse order, to check the canonical order.
+	var expected []util.ObjectReference
+	for i := range owners {
+		expected = append(expected, util.ToObjectReference(owners[i], sc))
+	}
+
+	for _, test := range []struct {
+		name    string
+		storage Storage
+		base    string
+		shards  int
+	}{
+		{
+			name:    "sharded",
+			storage: Storage{ShardSize: 512},
+			base:    OwnerAnnotation,
+			shards:  4,
+		},
+		{
+			name:    "compressed",
+			storage: Storage{Compress: true},
+			base:    CompressedOwnerAnnotation,
+			shards:  1,
+		},
+		{
+			name:    "sharded and compressed",
+			storage: Storage{ShardSize: 512, Compress: true},
+			base:    CompressedOwnerAnnotation,
+			shards:  4,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			obj := &corev1.Pod{ObjectMeta: v1.ObjectMeta{
+				Name:        "obj",
+				Namespace:   "default",
+				Annotations: map[string]string{"other": "value"},
+			}}
+			for i := len(owners) - 1; i >= 0; i-- {
+				// the first owners are stored in the default layout, and migrated later.
+				var opts []Option
+				if i < len(owners)-2 {
+					opts = append(opts, WithStorage(test.storage))
+				}
+				changed, err := InsertOwnerReference(owners[i], obj, sc, opts...)
+				require.NoError(t, err)
+				assert.True(t, changed)
+			}
+
+			refs, err := GetOwnerReferences(obj)
+			require.NoError(t, err)
+			assert.Equal(t, expected, refs)
+
+			assert.Len(t, obj.Annotations, test.shards+1)
+			for i := 0; i < test.shards; i++ {
+				value, ok := obj.Annotations[shardKey(test.base, i)]
+				if assert.True(t, ok, i) && test.storage.ShardSize > 0 && !test.storage.Compress {
+					assert.LessOrEqual(t, len(value), test.storage.ShardSize, i)
+				}
+			}
+
+			// reinserting an owner does not change anything.
+			changed, err := InsertOwnerReference(owners[0], obj, sc, WithStorage(test.storage))
+			require.NoError(t, err)
+			assert.False(t, changed)
+
+			// writing with the default storage migrates back to a single annotation.
+			changed, err = DeleteOwnerReference(owners[0], obj, sc)
+			require.NoError(t, err)
+			assert.True(t, changed)
+			assert.Len(t, obj.Annotations, 2)
+			refs, err = GetOwnerReferences(obj)
+			require.NoError(t, err)
+			assert.Equal(t, expected[1:], refs)
+		})
+	}
+}
+
+func TestIsOwnerAnnotation(t *testing.T) {
+	for key, expected := range map[string]bool{
+		OwnerAnnotation:                   true,
+		OwnerAnnotation + "-1":            true,
+		OwnerAnnotation + "-12":           true,
+		CompressedOwnerAnnotation:         true,
+		CompressedOwnerAnnotation + "-3":  true,
+		OwnerAnnotation + "-0":            false,
+		OwnerAnnotation + "-01":           false,
+		OwnerAnnotation + "-+1":           false,
+		OwnerAnnotation + "--1":           false,
+		OwnerAnnotation + "-backup":       false,
+		CompressedOwnerAnnotation + "-x1": false,
+		"other":                           false,
+	} {
+		assert.Equal(t, expected, isOwnerAnnotation(key), key)
+	}
+}
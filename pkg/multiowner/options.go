@@ -0,0 +1,82 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multiowner
+
+type options struct {
+	nativeOwnerReference bool
+	controller           bool
+	blockOwnerDeletion   bool
+
+	role Role
+
+	storage Storage
+
+	clusterName string
+}
+
+// Option configures how owner references are written and read.
+type Option func(*options)
+
+// WithNativeOwnerReference makes InsertOwnerReference also maintain a metav1.OwnerReference for each owner,
+// so the Kubernetes garbage collector and tools walking ownerReferences see the relation too.
+//
+// The native reference is only set for owners in the namespace of the object and cluster-scoped owners,
+// cross-namespace relations are recorded in the owner annotation only. Owners without UID are skipped as well,
+// just like owners in other clusters configured with WithClusterName.
+// Only one owner may be the controller of an object.
+// With this option, DeleteOwnerReference and DeleteObjectReference remove the native reference as well.
+func WithNativeOwnerReference(controller, blockOwnerDeletion bool) Option {
+	return func(o *options) {
+		o.nativeOwnerReference = true
+		o.controller = controller
+		o.blockOwnerDeletion = blockOwnerDeletion
+	}
+}
+
+// WithRole makes InsertOwnerReference record the given role of the owner.
+// EnqueueRequestForOwner and OwnedBy only match owners with the given role.
+func WithRole(role Role) Option {
+	return func(o *options) {
+		o.role = role
+	}
+}
+
+// WithStorage makes InsertOwnerReference and DeleteObjectReference store owner entries as configured.
+// Entries are read in any storage layout, so objects are migrated whenever their owners are written.
+func WithStorage(storage Storage) Option {
+	return func(o *options) {
+		o.storage = storage
+	}
+}
+
+// WithClusterName makes InsertOwnerReference label owned objects with the name of the cluster of the owner,
+// and record it in the owner entry, for objects in another cluster watched with util.WatchRemote.
+// InsertOwnerReference fails for objects labeled with another cluster,
+// and the label is removed together with the last owner.
+func WithClusterName(clusterName string) Option {
+	return func(o *options) {
+		o.clusterName = clusterName
+	}
+}
+
+func buildOptions(opts []Option) *options {
+	o := &options{}
+	for _, f := range opts {
+		f(o)
+	}
+	return o
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multiowner
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"k8c.io/utils/pkg/util"
+)
+
+// PatchInsertOwnerReference adds owner to obj like InsertOwnerReference, and patches obj in the cluster.
+// See patchOwnerReferences for how concurrent changes are handled.
+// obj is updated to the patched state and the final list of owners is returned.
+func PatchInsertOwnerReference(ctx context.Context, cl client.Client, scheme *runtime.Scheme, owner, obj object, opts ...Option) ([]util.ObjectReference, error) {
+	return patchOwnerReferences(ctx, cl, obj, func(modified object) (bool, error) {
+		return InsertOwnerReference(owner, modified, scheme, opts...)
+	})
+}
+
+// PatchDeleteOwnerReference removes owner from obj like DeleteOwnerReference, and patches obj in the cluster.
+// See patchOwnerReferences for how concurrent changes are handled.
+// obj is updated to the patched state and the final list of owners is returned.
+func PatchDeleteOwnerReference(ctx context.Context, cl client.Client, scheme *runtime.Scheme, owner, obj object, opts ...Option) ([]util.ObjectReference, error) {
+	return PatchDeleteObjectReference(ctx, cl, util.ToObjectReference(owner, scheme), obj, opts...)
+}
+
+// PatchDeleteObjectReference removes the referenced owner from obj like DeleteObjectReference,
+// and patches obj in the cluster.
+// See patchOwnerReferences for how concurrent changes are handled.
+// obj is updated to the patched state and the final list of owners is returned.
+func PatchDeleteObjectReference(ctx context.Context, cl client.Client, reference util.ObjectReference, obj object, opts ...Option) ([]util.ObjectReference, error) {
+	return patchOwnerReferences(ctx, cl, obj, func(modified object) (bool, error) {
+		return DeleteObjectReference(reference, modified, opts...)
+	})
+}
+
+// patchOwnerReferences applies mutate to obj and sends the changed owners as JSON patch,
+// which only applies to the resourceVersion of obj. Other fields of obj are not sent.
+// When obj was changed meanwhile, it is read again and mutate is retried on the fresh copy,
+// so owners written concurrently by other controllers are never lost.
+func patchOwnerReferences(ctx context.Context, cl client.Client, obj object, mutate func(obj object) (bool, error)) ([]util.ObjectReference, error) {
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	first := true
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if !first {
+			if err := refresh(ctx, cl, key, obj); err != nil {
+				return err
+			}
+		}
+		first = false
+
+		modified, ok := obj.DeepCopyObject().(object)
+		if !ok {
+			return fmt.Errorf("%T is not a metav1.Object", obj)
+		}
+		changed, err := mutate(modified)
+		if err != nil || !changed {
+			return err
+		}
+		patch, err := ownerReferencesPatch(obj, modified)
+		if err != nil {
+			return err
+		}
+		// the response is decoded into modified, so removed map entries do not survive as in obj.
+		if err := cl.Patch(ctx, modified, patch); err != nil {
+			return patchError(ctx, cl, key, obj, err)
+		}
+		reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(modified).Elem())
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("patching owners of %s/%s: %w", key.Namespace, key.Name, err)
+	}
+	return getRefs(obj)
+}
+
+// refresh reads obj again. It reads into an empty object,
+// as decoding into obj would keep map entries, which were removed meanwhile.
+func refresh(ctx context.Context, cl client.Client, key client.ObjectKey, obj object) error {
+	fresh := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(runtime.Object)
+	fresh.GetObjectKind().SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+	if err := cl.Get(ctx, key, fresh); err != nil {
+		return err
+	}
+	reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(fresh).Elem())
+	return nil
+}
+
+// patchError converts the error of a patch, which failed because obj was changed meanwhile, into a Conflict.
+// The API server reports a failed test operation as 422 Invalid like any other invalid patch,
+// so obj is read again and only a changed resourceVersion is reported as Conflict.
+func patchError(ctx context.Context, cl client.Client, key client.ObjectKey, obj object, err error) error {
+	if !errors.IsInvalid(err) {
+		return err
+	}
+	live, ok := obj.DeepCopyObject().(object)
+	if !ok {
+		return err
+	}
+	if refresh(ctx, cl, key, live) != nil || live.GetResourceVersion() == obj.GetResourceVersion() {
+		return err
+	}
+	return errors.NewConflict(schema.GroupResource{}, key.Name, err)
+}
+
+// ownerReferencesPatch returns a JSON patch changing the owner annotation and ownerReferences from old to new,
+// which only applies to the resourceVersion of old.
+func ownerReferencesPatch(old, new object) (client.Patch, error) {
+	resourceVersion := old.GetResourceVersion()
+	if resourceVersion == "" {
+		return nil, fmt.Errorf("object has no resourceVersion")
+	}
+	ops := []util.JSONPatchOperation{
+		{Op: "test", Path: "/metadata/resourceVersion", Value: resourceVersion},
+	}
+
+	oldAnnotations, newAnnotations := old.GetAnnotations(), new.GetAnnotations()
+	if oldAnnotations == nil {
+		ownerAnnotations := map[string]string{}
+		for k, v := range newAnnotations {
+			if isOwnerAnnotation(k) {
+				ownerAnnotations[k] = v
+			}
+		}
+		if len(ownerAnnotations) > 0 {
+			ops = append(ops, util.JSONPatchOperation{Op: "add", Path: "/metadata/annotations", Value: ownerAnnotations})
+		}
+	} else {
+		// entries may be sharded across several annotations, see Storage.
+		for _, k := range sortedKeys(oldAnnotations, newAnnotations) {
+			if !isOwnerAnnotation(k) {
+				continue
+			}
+			oldValue, hadValue := oldAnnotations[k]
+			newValue, hasValue := newAnnotations[k]
+			switch {
+			case hasValue && (!hadValue || oldValue != newValue):
+				ops = append(ops, util.JSONPatchOperation{Op: "add", Path: "/metadata/annotations/" + util.EscapeJSONPointer(k), Value: newValue})
+			case !hasValue && hadValue:
+				ops = append(ops, util.JSONPatchOperation{Op: "remove", Path: "/metadata/annotations/" + util.EscapeJSONPointer(k)})
+			}
+		}
+	}
+
+	// the ClusterLabel is set and removed together with owners, see WithClusterName.
+	oldCluster, hadCluster := old.GetLabels()[util.ClusterLabel]
+	newCluster, hasCluster := new.GetLabels()[util.ClusterLabel]
+	switch {
+	case hasCluster && old.GetLabels() == nil:
+		ops = append(ops, util.JSONPatchOperation{Op: "add", Path: "/metadata/labels", Value: map[string]string{util.ClusterLabel: newCluster}})
+	case hasCluster && (!hadCluster || oldCluster != newCluster):
+		ops = append(ops, util.JSONPatchOperation{Op: "add", Path: "/metadata/labels/" + util.EscapeJSONPointer(util.ClusterLabel), Value: newCluster})
+	case !hasCluster && hadCluster:
+		ops = append(ops, util.JSONPatchOperation{Op: "remove", Path: "/metadata/labels/" + util.EscapeJSONPointer(util.ClusterLabel)})
+	}
+
+	oldRefs, newRefs := old.GetOwnerReferences(), new.GetOwnerReferences()
+	switch {
+	case len(newRefs) == 0 && len(oldRefs) > 0:
+		ops = append(ops, util.JSONPatchOperation{Op: "remove", Path: "/metadata/ownerReferences"})
+	case len(newRefs) > 0 && !equality.Semantic.DeepEqual(oldRefs, newRefs):
+		ops = append(ops, util.JSONPatchOperation{Op: "add", Path: "/metadata/ownerReferences", Value: newRefs})
+	}
+
+	return util.NewJSONPatch(ops...)
+}
+
+// sortedKeys returns the keys of all given maps in a stable order.
+func sortedKeys(maps ...map[string]string) []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, m := range maps {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multiowner
+
+import (
+	"context"
+	goerrors "errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"k8c.io/utils/pkg/testutil"
+	"k8c.io/utils/pkg/util"
+)
+
+func TestPatchOwnerReferences(t *testing.T) {
+	sc := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(sc))
+
+	ownerA := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "A", Namespace: "default", UID: "a-uid"}}
+	ownerB := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "B", Namespace: "default", UID: "b-uid"}}
+	refA, refB := util.ToObjectReference(ownerA, sc), util.ToObjectReference(ownerB, sc)
+	ctx := context.Background()
+
+	newClient := func(t *testing.T) (client.Client, *corev1.ConfigMap) {
+		cl := fakeclient.NewFakeClientWithScheme(sc)
+		cm := &corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{
+			Name:        "shared",
+			Namespace:   "default",
+			Annotations: map[string]string{"other": "value"},
+		}}
+		require.NoError(t, cl.Create(ctx, cm))
+		return cl, cm
+	}
+	getConfigMap := func(t *testing.T, cl client.Client) *corev1.ConfigMap {
+		cm := &corev1.ConfigMap{}
+		require.NoError(t, cl.Get(ctx, client.ObjectKey{Name: "shared", Namespace: "default"}, cm))
+		return cm
+	}
+
+	t.Run("insert and delete", func(t *testing.T) {
+		cl, cm := newClient(t)
+		owners, err := PatchInsertOwnerReference(ctx, cl, sc, ownerA, cm)
+		require.NoError(t, err)
+		assert.Equal(t, []util.ObjectReference{refA}, owners)
+		owners, err = PatchInsertOwnerReference(ctx, cl, sc, ownerB, cm)
+		require.NoError(t, err)
+		assert.Equal(t, []util.ObjectReference{refA, refB}, owners)
+
+		live := getConfigMap(t, cl)
+		assert.Equal(t, "value", live.Annotations["other"])
+		refs, err := GetOwnerReferences(live)
+		require.NoError(t, err)
+		assert.Equal(t, []util.ObjectReference{refA, refB}, refs)
+
+		owners, err = PatchDeleteOwnerReference(ctx, cl, sc, ownerA, cm)
+		require.NoError(t, err)
+		assert.Equal(t, []util.ObjectReference{refB}, owners)
+		owners, err = PatchDeleteObjectReference(ctx, cl, refB, cm)
+		require.NoError(t, err)
+		assert.Empty(t, owners)
+		assert.Equal(t, map[string]string{"other": "value"}, getConfigMap(t, cl).Annotations)
+	})
+
+	t.Run("concurrent insert", func(t *testing.T) {
+		cl, cm := newClient(t)
+		racing, raced := newRacingClient(cl, sc, ownerB)
+
+		// cm is stale after B inserted itself, the patch is retried on a fresh copy.
+		owners, err := PatchInsertOwnerReference(ctx, racing, sc, ownerA, cm)
+		require.NoError(t, err)
+		assert.True(t, *raced)
+		assert.Equal(t, []util.ObjectReference{refB, refA}, owners)
+		refs, err := GetOwnerReferences(getConfigMap(t, cl))
+		require.NoError(t, err)
+		assert.Equal(t, []util.ObjectReference{refB, refA}, refs)
+	})
+
+	t.Run("invalid patch", func(t *testing.T) {
+		cl, cm := newClient(t)
+		// the object is unchanged, so the Invalid error is not caused by the resourceVersion test.
+		var patches int
+		invalid := &testutil.InterceptingClient{
+			Client: cl,
+			PatchFunc: func(ctx context.Context, cl client.Client, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+				patches++
+				return errors.NewInvalid(corev1.SchemeGroupVersion.WithKind("ConfigMap").GroupKind(), "shared", field.ErrorList{
+					field.Invalid(field.NewPath("metadata", "ownerReferences"), nil, "invalid"),
+				})
+			},
+		}
+		_, err := PatchInsertOwnerReference(ctx, invalid, sc, ownerA, cm)
+		require.Error(t, err)
+		assert.True(t, errors.IsInvalid(goerrors.Unwrap(err)))
+		assert.Equal(t, 1, patches, "invalid patches must not be retried")
+	})
+
+	t.Run("cluster name", func(t *testing.T) {
+		cl, cm := newClient(t)
+		_, err := PatchInsertOwnerReference(ctx, cl, sc, ownerA, cm, WithClusterName("a"))
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{util.ClusterLabel: "a"}, getConfigMap(t, cl).Labels)
+
+		_, err = PatchDeleteOwnerReference(ctx, cl, sc, ownerA, cm)
+		require.NoError(t, err)
+		assert.Empty(t, getConfigMap(t, cl).Labels)
+	})
+
+	t.Run("sharded storage", func(t *testing.T) {
+		cl, cm := newClient(t)
+		storage := WithStorage(Storage{ShardSize: 1})
+		_, err := PatchInsertOwnerReference(ctx, cl, sc, ownerA, cm, storage)
+		require.NoError(t, err)
+		owners, err := PatchInsertOwnerReference(ctx, cl, sc, ownerB, cm, storage)
+		require.NoError(t, err)
+		assert.Equal(t, []util.ObjectReference{refA, refB}, owners)
+		live := getConfigMap(t, cl)
+		assert.Contains(t, live.Annotations, OwnerAnnotation)
+		assert.Contains(t, live.Annotations, OwnerAnnotation+"-1")
+
+		owners, err = PatchDeleteOwnerReference(ctx, cl, sc, ownerA, cm, storage)
+		require.NoError(t, err)
+		assert.Equal(t, []util.ObjectReference{refB}, owners)
+		live = getConfigMap(t, cl)
+		assert.Contains(t, live.Annotations, OwnerAnnotation)
+		assert.NotContains(t, live.Annotations, OwnerAnnotation+"-1")
+	})
+
+	t.Run("native owner references", func(t *testing.T) {
+		cl, cm := newClient(t)
+		_, err := PatchInsertOwnerReference(ctx, cl, sc, ownerA, cm, WithNativeOwnerReference(false, false))
+		require.NoError(t, err)
+		live := getConfigMap(t, cl)
+		if assert.Len(t, live.OwnerReferences, 1) {
+			assert.Equal(t, ownerA.UID, live.OwnerReferences[0].UID)
+		}
+
+		_, err = PatchDeleteOwnerReference(ctx, cl, sc, ownerA, cm, WithNativeOwnerReference(false, false))
+		require.NoError(t, err)
+		assert.Empty(t, getConfigMap(t, cl).OwnerReferences)
+	})
+}
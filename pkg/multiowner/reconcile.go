@@ -0,0 +1,210 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multiowner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"k8c.io/utils/pkg/util"
+)
+
+// updateFunc is called to update the current existing object (actual) to the desired state.
+type updateFunc func(actual, desired runtime.Object) error
+
+// ReconcileOwnedObjects ensures that owner is recorded on all desired objects and removed from
+// all other objects of objectType it owns. Objects are shared between owners:
+// desired objects are created if missing, and objects are only deleted once no owner remains.
+//
+// In case a desired object already exists, the updateFn function is called allowing the user fixing
+// between found and wanted object. In case the function is nil it's ignored.
+//
+// Owned objects are found with ListOwnedObjects, which uses the owner reverse field index if it is registered
+// for objectType, see AddOwnerReverseFieldIndex. Pass *unstructured.Unstructured objects and type hints
+// for types unknown to the scheme.
+//
+// Several owners may reconcile the same shared object at once. Updates are sent with the resourceVersion
+// that was read and deletions with a resourceVersion precondition, and conflicts are retried on a fresh copy,
+// so no owner reference is lost and objects are never deleted while a concurrent owner inserts itself.
+// Options are passed down to InsertOwnerReference and DeleteOwnerReference. A nil log discards all messages.
+func ReconcileOwnedObjects(ctx context.Context, cl client.Client, log logr.Logger, scheme *runtime.Scheme, owner object, desired []runtime.Object, objectType runtime.Object, updateFn updateFunc, opts ...Option) (changed bool, err error) {
+	if log == nil {
+		log = ctrllog.NullLogger{}
+	}
+	ownerRef := util.ToObjectReference(owner, scheme)
+
+	desiredKeys := map[client.ObjectKey]bool{}
+	for _, obj := range desired {
+		key, err := client.ObjectKeyFromObject(obj)
+		if err != nil {
+			return changed, err
+		}
+		desiredKeys[key] = true
+
+		objChanged, err := insertOwner(ctx, cl, scheme, owner, obj, updateFn, opts)
+		if err != nil {
+			return changed, fmt.Errorf("reconciling %s: %w", util.MustLogLine(obj, scheme), err)
+		}
+		if objChanged {
+			log.V(6).Info("owner inserted", "object", util.MustLogLine(obj, scheme))
+		}
+		changed = changed || objChanged
+	}
+
+	objs, err := listOwned(ctx, cl, scheme, ownerRef, "", []runtime.Object{objectType})
+	if err != nil {
+		return changed, err
+	}
+	for _, obj := range objs {
+		key, err := client.ObjectKeyFromObject(obj)
+		if err != nil {
+			return changed, err
+		}
+		if desiredKeys[key] {
+			continue
+		}
+
+		objChanged, deleted, err := removeOwner(ctx, cl, obj, ownerRef, opts)
+		if err != nil {
+			return changed, fmt.Errorf("releasing %s: %w", util.MustLogLine(obj, scheme), err)
+		}
+		switch {
+		case deleted:
+			log.V(6).Info("object deleted", "object", util.MustLogLine(obj, scheme))
+		case objChanged:
+			log.V(6).Info("owner removed", "object", util.MustLogLine(obj, scheme))
+		}
+		changed = changed || objChanged
+	}
+	return changed, nil
+}
+
+// insertOwner creates the desired object owned by owner, or adds owner to the existing object.
+func insertOwner(ctx context.Context, cl client.Client, scheme *runtime.Scheme, owner object, desired runtime.Object, updateFn updateFunc, opts []Option) (changed bool, err error) {
+	key, err := client.ObjectKeyFromObject(desired)
+	if err != nil {
+		return false, err
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		changed = false
+		obj := desired.DeepCopyObject()
+		actual, ok := obj.(object)
+		if !ok {
+			return fmt.Errorf("%T is not a metav1.Object", obj)
+		}
+
+		err := cl.Get(ctx, key, obj)
+		if errors.IsNotFound(err) {
+			actual, ok = desired.DeepCopyObject().(object)
+			if !ok {
+				return fmt.Errorf("%T is not a metav1.Object", desired)
+			}
+			if _, err := InsertOwnerReference(owner, actual, scheme, opts...); err != nil {
+				return err
+			}
+			err = cl.Create(ctx, actual)
+			if errors.IsAlreadyExists(err) {
+				// another owner created it meanwhile, insert into the existing object instead.
+				return errors.NewConflict(schema.GroupResource{}, key.Name, err)
+			}
+			changed = err == nil
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		existing := obj.DeepCopyObject()
+		if _, err := InsertOwnerReference(owner, actual, scheme, opts...); err != nil {
+			return err
+		}
+		if updateFn != nil {
+			if err := updateFn(obj, desired); err != nil {
+				return err
+			}
+		}
+		if equality.Semantic.DeepEqual(existing, obj) {
+			return nil
+		}
+		// the resourceVersion read above makes concurrent updates of other owners conflict.
+		if err := cl.Update(ctx, obj); err != nil {
+			return err
+		}
+		changed = true
+		return nil
+	})
+	return changed, err
+}
+
+// removeOwner removes the owner from obj and deletes obj, if no owners remain.
+func removeOwner(ctx context.Context, cl client.Client, obj runtime.Object, ownerRef util.ObjectReference, opts []Option) (changed, deleted bool, err error) {
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return false, false, err
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		changed, deleted = false, false
+		actual, ok := obj.DeepCopyObject().(object)
+		if !ok {
+			return fmt.Errorf("%T is not a metav1.Object", obj)
+		}
+		if err := cl.Get(ctx, key, actual); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+
+		removed, err := DeleteObjectReference(ownerRef, actual, opts...)
+		if err != nil || !removed {
+			return err
+		}
+		owned, err := IsOwned(actual)
+		if err != nil {
+			return err
+		}
+		if owned {
+			err = cl.Update(ctx, actual)
+			changed = err == nil
+			return err
+		}
+
+		// the preconditions make the deletion fail, if another owner inserted itself meanwhile.
+		var preconditions client.Preconditions
+		if uid := actual.GetUID(); uid != "" {
+			preconditions.UID = &uid
+		}
+		if resourceVersion := actual.GetResourceVersion(); resourceVersion != "" {
+			preconditions.ResourceVersion = &resourceVersion
+		}
+		err = cl.Delete(ctx, actual, preconditions)
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		changed, deleted = err == nil, err == nil
+		return err
+	})
+	return changed, deleted, err
+}
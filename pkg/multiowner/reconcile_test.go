@@ -0,0 +1,187 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multiowner
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"k8c.io/utils/pkg/testutil"
+	"k8c.io/utils/pkg/util"
+)
+
+// newRacingClient lets racer insert itself into an object right before the first update, patch or delete,
+// reports failed patches like the API server does, and enforces delete preconditions, which the fake client ignores.
+// raced reports whether racer inserted itself.
+func newRacingClient(cl client.Client, scheme *runtime.Scheme, racer object) (racing *testutil.InterceptingClient, raced *bool) {
+	raced = new(bool)
+	race := func(ctx context.Context, cl client.Client, obj runtime.Object) error {
+		if *raced {
+			return nil
+		}
+		*raced = true
+		key, err := client.ObjectKeyFromObject(obj)
+		if err != nil {
+			return err
+		}
+		cm := &corev1.ConfigMap{}
+		if err := cl.Get(ctx, key, cm); err != nil {
+			return err
+		}
+		if _, err := InsertOwnerReference(racer, cm, scheme); err != nil {
+			return err
+		}
+		return cl.Update(ctx, cm)
+	}
+	return &testutil.InterceptingClient{
+		Client: cl,
+		UpdateFunc: func(ctx context.Context, cl client.Client, obj runtime.Object, opts ...client.UpdateOption) error {
+			if err := race(ctx, cl, obj); err != nil {
+				return err
+			}
+			return cl.Update(ctx, obj, opts...)
+		},
+		PatchFunc: func(ctx context.Context, cl client.Client, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if err := race(ctx, cl, obj); err != nil {
+				return err
+			}
+			if err := cl.Patch(ctx, obj, patch, opts...); err != nil {
+				return errors.NewGenericServerResponse(http.StatusUnprocessableEntity, "", schema.GroupResource{}, "", err.Error(), 0, false)
+			}
+			return nil
+		},
+		DeleteFunc: func(ctx context.Context, cl client.Client, obj runtime.Object, opts ...client.DeleteOption) error {
+			if err := race(ctx, cl, obj); err != nil {
+				return err
+			}
+			return testutil.DeleteWithPreconditions(ctx, cl, obj, opts...)
+		},
+	}, raced
+}
+
+func TestReconcileOwnedObjects(t *testing.T) {
+	sc := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(sc))
+
+	ownerA := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "A", Namespace: "default"}}
+	ownerB := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "B", Namespace: "default"}}
+	newConfigMap := func(name string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "default"},
+			Data:       map[string]string{"name": name},
+		}
+	}
+	updateFn := func(actual, desired runtime.Object) error {
+		actual.(*corev1.ConfigMap).Data = desired.(*corev1.ConfigMap).Data
+		return nil
+	}
+	ctx := context.Background()
+	log := testutil.NewLogger(t)
+
+	getOwners := func(t *testing.T, cl client.Client, name string) []util.ObjectReference {
+		cm := &corev1.ConfigMap{}
+		err := cl.Get(ctx, client.ObjectKey{Name: name, Namespace: "default"}, cm)
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		require.NoError(t, err)
+		refs, err := GetOwnerReferences(cm)
+		require.NoError(t, err)
+		return refs
+	}
+	refA, refB := util.ToObjectReference(ownerA, sc), util.ToObjectReference(ownerB, sc)
+
+	t.Run("shared objects", func(t *testing.T) {
+		cl := fakeclient.NewFakeClientWithScheme(sc)
+
+		changed, err := ReconcileOwnedObjects(ctx, cl, log, sc, ownerA, []runtime.Object{newConfigMap("shared"), newConfigMap("a-only")}, &corev1.ConfigMap{}, updateFn)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		changed, err = ReconcileOwnedObjects(ctx, cl, log, sc, ownerB, []runtime.Object{newConfigMap("shared")}, &corev1.ConfigMap{}, updateFn)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, []util.ObjectReference{refA, refB}, getOwners(t, cl, "shared"))
+		assert.Equal(t, []util.ObjectReference{refA}, getOwners(t, cl, "a-only"))
+
+		// idempotent repeat
+		changed, err = ReconcileOwnedObjects(ctx, cl, log, sc, ownerB, []runtime.Object{newConfigMap("shared")}, &corev1.ConfigMap{}, updateFn)
+		require.NoError(t, err)
+		assert.False(t, changed)
+
+		// A releases everything, the shared object is kept for B.
+		changed, err = ReconcileOwnedObjects(ctx, cl, log, sc, ownerA, nil, &corev1.ConfigMap{}, updateFn)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, []util.ObjectReference{refB}, getOwners(t, cl, "shared"))
+		assert.Nil(t, getOwners(t, cl, "a-only"))
+		assert.True(t, errors.IsNotFound(cl.Get(ctx, client.ObjectKey{Name: "a-only", Namespace: "default"}, &corev1.ConfigMap{})))
+
+		// the last owner deletes the object.
+		_, err = ReconcileOwnedObjects(ctx, cl, log, sc, ownerB, nil, &corev1.ConfigMap{}, updateFn)
+		require.NoError(t, err)
+		assert.True(t, errors.IsNotFound(cl.Get(ctx, client.ObjectKey{Name: "shared", Namespace: "default"}, &corev1.ConfigMap{})))
+	})
+
+	t.Run("nil logger", func(t *testing.T) {
+		cl := fakeclient.NewFakeClientWithScheme(sc)
+
+		changed, err := ReconcileOwnedObjects(ctx, cl, nil, sc, ownerA, []runtime.Object{newConfigMap("a-only")}, &corev1.ConfigMap{}, updateFn)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		changed, err = ReconcileOwnedObjects(ctx, cl, nil, sc, ownerA, nil, &corev1.ConfigMap{}, updateFn)
+		require.NoError(t, err)
+		assert.True(t, changed)
+	})
+
+	t.Run("concurrent insert", func(t *testing.T) {
+		cl := fakeclient.NewFakeClientWithScheme(sc)
+		_, err := ReconcileOwnedObjects(ctx, cl, log, sc, ownerA, []runtime.Object{newConfigMap("shared")}, &corev1.ConfigMap{}, updateFn)
+		require.NoError(t, err)
+
+		// the update of C conflicts with B inserting itself.
+		ownerC := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "C", Namespace: "default"}}
+		racing, raced := newRacingClient(cl, sc, ownerB)
+		_, err = ReconcileOwnedObjects(ctx, racing, log, sc, ownerC, []runtime.Object{newConfigMap("shared")}, &corev1.ConfigMap{}, updateFn)
+		require.NoError(t, err)
+		assert.True(t, *raced)
+		assert.Equal(t, []util.ObjectReference{refA, refB, util.ToObjectReference(ownerC, sc)}, getOwners(t, cl, "shared"))
+	})
+
+	t.Run("concurrent insert while deleting", func(t *testing.T) {
+		cl := fakeclient.NewFakeClientWithScheme(sc)
+		_, err := ReconcileOwnedObjects(ctx, cl, log, sc, ownerA, []runtime.Object{newConfigMap("shared")}, &corev1.ConfigMap{}, updateFn)
+		require.NoError(t, err)
+
+		// B inserts itself, right before A deletes the object it believes to be the last owner of.
+		racing, raced := newRacingClient(cl, sc, ownerB)
+		_, err = ReconcileOwnedObjects(ctx, racing, log, sc, ownerA, nil, &corev1.ConfigMap{}, updateFn)
+		require.NoError(t, err)
+		assert.True(t, *raced)
+		assert.Equal(t, []util.ObjectReference{refB}, getOwners(t, cl, "shared"))
+	})
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multiowner
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"k8c.io/utils/pkg/util"
+)
+
+// Role describes how an owner relates to an object.
+type Role string
+
+const (
+	// RoleController is used for owners, which manage the object.
+	RoleController Role = "Controller"
+	// RoleConsumer is used for owners, which only use the object and keep it alive.
+	RoleConsumer Role = "Consumer"
+)
+
+// OwnerReference is an entry of the OwnerAnnotation.
+//
+// The extra fields are optional and omitted when empty, so entries written by older versions
+// are read as OwnerReferences without UID, role, timestamp and cluster, and older versions ignore the extra fields.
+type OwnerReference struct {
+	util.ObjectReference
+	// UID of the owner, when it was inserted.
+	UID types.UID `json:"uid,omitempty"`
+	// Role of the owner, see WithRole.
+	Role Role `json:"role,omitempty"`
+	// Added is the time the owner was inserted.
+	Added *metav1.Time `json:"added,omitempty"`
+	// Cluster is the name of the cluster of the owner, see WithClusterName.
+	// Empty for owners in the cluster of the object.
+	Cluster string `json:"cluster,omitempty"`
+}
+
+// GetOwners returns all owner entries recorded on the given object.
+func GetOwners(object metav1.Object) ([]OwnerReference, error) {
+	return getEntries(object)
+}
+
+// OwnerRecreated returns true, if object records another UID for owner than its current one.
+// This is the case, when owner was deleted and recreated with the same name.
+// Entries and owners without UID are never reported.
+func OwnerRecreated(owner, object object, scheme *runtime.Scheme) (bool, error) {
+	ownerReference := util.ToObjectReference(owner, scheme)
+	entries, err := getEntries(object)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if entry.ObjectReference == ownerReference {
+			return entry.UID != "" && owner.GetUID() != "" && entry.UID != owner.GetUID(), nil
+		}
+	}
+	return false, nil
+}
+
+func getRefs(object metav1.Object) (refs []util.ObjectReference, err error) {
+	entries, err := getEntries(object)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		refs = append(refs, entry.ObjectReference)
+	}
+	return refs, nil
+}
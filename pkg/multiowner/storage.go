@@ -0,0 +1,247 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multiowner
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// CompressedOwnerAnnotation holds the gzip compressed and base64 encoded owner entries,
+	// when they are stored with Storage.Compress.
+	CompressedOwnerAnnotation = OwnerAnnotation + "-compressed"
+)
+
+// Storage configures how owner entries are stored in annotations.
+//
+// By default, all entries are stored as a single JSON array in the OwnerAnnotation, in insertion order.
+// Objects in any layout can always be read, so the storage of existing objects can be changed at any time,
+// and objects are migrated whenever their owners are written.
+//
+// Keep in mind that the Kubernetes API limits the total size of all annotations of an object to 256KiB.
+// Sharding only keeps single annotation values small, compression also reduces the total size.
+type Storage struct {
+	// ShardSize limits the size of a single annotation value, before compression. Entries are split across the OwnerAnnotation
+	// and further annotations with a numeric suffix, like kubermatic.io/owner-1.
+	// Every annotation holds a complete JSON array, so older versions still read the entries of the first one.
+	// 0 stores all entries in a single annotation.
+	ShardSize int
+	// Compress stores the entries gzip compressed and base64 encoded in the CompressedOwnerAnnotation,
+	// sharded the same way.
+	// Compressed entries cannot be read by older versions, so only enable it once all readers are updated.
+	Compress bool
+}
+
+// enabled returns true, if entries are not stored in the default layout.
+func (s Storage) enabled() bool {
+	return s.ShardSize > 0 || s.Compress
+}
+
+// shardKey returns the annotation key of the i-th shard.
+func shardKey(base string, i int) string {
+	if i == 0 {
+		return base
+	}
+	return base + "-" + strconv.Itoa(i)
+}
+
+// isOwnerAnnotation returns true for all annotations owner entries are stored in,
+// i.e. the base annotations and their shards as named by shardKey.
+func isOwnerAnnotation(key string) bool {
+	for _, base := range []string{OwnerAnnotation, CompressedOwnerAnnotation} {
+		if key == base {
+			return true
+		}
+		if suffix := strings.TrimPrefix(key, base+"-"); suffix != key {
+			// only the canonical numeric suffix, e.g. not "-01" or "-+1".
+			if i, err := strconv.Atoi(suffix); err == nil && i > 0 && shardKey(base, i) == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func getEntries(object metav1.Object) (entries []OwnerReference, err error) {
+	annotations := object.GetAnnotations()
+	if annotations == nil {
+		return nil, nil
+	}
+
+	for _, base := range []string{OwnerAnnotation, CompressedOwnerAnnotation} {
+		for i := 0; ; i++ {
+			data, present := annotations[shardKey(base, i)]
+			if !present {
+				break
+			}
+			shard, err := parseShard(data, base == CompressedOwnerAnnotation)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", shardKey(base, i), err)
+			}
+			entries = append(entries, shard...)
+		}
+	}
+	return entries, nil
+}
+
+func parseShard(data string, compressed bool) ([]OwnerReference, error) {
+	raw := []byte(data)
+	if compressed {
+		var err error
+		if raw, err = decompress(data); err != nil {
+			return nil, err
+		}
+	}
+	var entries []OwnerReference
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// setEntries stores the entries in the annotations of object, replacing all previously stored entries.
+// It reports whether the annotations changed.
+func setEntries(object metav1.Object, entries []OwnerReference, storage Storage) (changed bool, err error) {
+	values, err := encodeEntries(entries, storage)
+	if err != nil {
+		return false, err
+	}
+
+	annotations := object.GetAnnotations()
+	if annotations == nil {
+		if len(values) == 0 {
+			return false, nil
+		}
+		annotations = make(map[string]string)
+	}
+	for k, v := range annotations {
+		if !isOwnerAnnotation(k) {
+			continue
+		}
+		if _, keep := values[k]; !keep {
+			delete(annotations, k)
+			changed = true
+		} else if values[k] != v {
+			changed = true
+		}
+	}
+	for k, v := range values {
+		if _, present := annotations[k]; !present {
+			changed = true
+		}
+		annotations[k] = v
+	}
+	object.SetAnnotations(annotations)
+	return changed, nil
+}
+
+// encodeEntries returns the owner annotations for the given entries.
+func encodeEntries(entries []OwnerReference, storage Storage) (map[string]string, error) {
+	values := map[string]string{}
+	if len(entries) == 0 {
+		return values, nil
+	}
+	if !storage.enabled() {
+		b, err := json.Marshal(entries)
+		if err != nil {
+			return nil, err
+		}
+		values[OwnerAnnotation] = string(b)
+		return values, nil
+	}
+
+	// a canonical order makes the stored value independent of the insertion order.
+	entries = append([]OwnerReference(nil), entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].ObjectReference.String() < entries[j].ObjectReference.String()
+	})
+	base := OwnerAnnotation
+	if storage.Compress {
+		base = CompressedOwnerAnnotation
+	}
+
+	// shards are cut by the size of their JSON array, so the value of a compressed shard is even smaller.
+	shards := [][]OwnerReference{nil}
+	size := len("[]")
+	for _, entry := range entries {
+		b, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		entrySize := len(b) + len(",")
+		current := shards[len(shards)-1]
+		if storage.ShardSize > 0 && len(current) > 0 && size+entrySize > storage.ShardSize {
+			// a single entry exceeding the shard size is stored on its own.
+			shards = append(shards, nil)
+			size = len("[]")
+		}
+		shards[len(shards)-1] = append(shards[len(shards)-1], entry)
+		size += entrySize
+	}
+
+	for i, shard := range shards {
+		value, err := encodeShard(shard, storage.Compress)
+		if err != nil {
+			return nil, err
+		}
+		values[shardKey(base, i)] = value
+	}
+	return values, nil
+}
+
+func encodeShard(entries []OwnerReference, compressed bool) (string, error) {
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return "", err
+	}
+	if !compressed {
+		return string(b), nil
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decompress(data string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
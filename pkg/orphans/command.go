@@ -0,0 +1,127 @@
+/*
+Copyright 2020 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orphans
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/cobra"
+
+	"k8c.io/utils/pkg/util"
+)
+
+type Flags struct {
+	*util.KubeFlags
+	Delete bool
+	Strip  bool
+}
+
+func NewFlags() *Flags {
+	return &Flags{KubeFlags: util.NewKubeFlags()}
+}
+
+func (f *Flags) NewCommand(log logr.Logger, use string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: use,
+		Long: strings.TrimSpace(`
+Finds objects with owner.kubermatic.io/* labels or a kubermatic.io/owner annotation,
+that reference owners which no longer exist.
+
+Objects, where none of the owners exist anymore, are reported as orphaned and can be deleted with --delete.
+Dangling owner references can be removed with --strip, keeping the objects.
+References to a recreated owner with another UID are only reported and never acted upon.
+`),
+		Short: "find objects with dangling owner references",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if f.Delete && f.Strip {
+				return fmt.Errorf("--delete and --strip are mutually exclusive")
+			}
+			if err := f.Validate(); err != nil {
+				return err
+			}
+
+			clients, err := f.NewClients()
+			if err != nil {
+				return err
+			}
+
+			ctx, closeCtx := context.WithCancel(context.Background())
+			defer closeCtx()
+
+			objTypes, err := ListableTypes(log, clients.Discovery)
+			if err != nil {
+				return err
+			}
+
+			listOptions, err := f.ListOptions()
+			if err != nil {
+				return err
+			}
+
+			finder := &Finder{
+				Client: clients.Client,
+				Mapper: clients.Mapper,
+				Scheme: clients.Scheme,
+				Log:    log,
+			}
+			orphans, err := finder.Find(ctx, objTypes, listOptions...)
+			if err != nil {
+				return err
+			}
+			if err := printOrphans(cmd.OutOrStdout(), f.Output, orphans); err != nil {
+				return err
+			}
+
+			switch {
+			case f.Delete:
+				return finder.Delete(ctx, orphans)
+			case f.Strip:
+				return finder.Strip(ctx, orphans)
+			}
+			return nil
+		},
+	}
+
+	f.AddFlags(cmd, "search objects in all namespaces")
+	cmd.Flags().BoolVar(&f.Delete, "delete", f.Delete, "delete orphaned objects")
+	cmd.Flags().BoolVar(&f.Strip, "strip", f.Strip, "remove dangling owner references")
+	return cmd
+}
+
+func printOrphans(w io.Writer, output string, orphans []Orphan) error {
+	var rows [][]string
+	for _, orphan := range orphans {
+		for _, dangling := range orphan.DanglingReferences {
+			rows = append(rows, []string{
+				orphan.Object.Kind + "." + orphan.Object.Group,
+				orphan.Object.Namespace,
+				orphan.Object.Name,
+				string(dangling.Encoding),
+				dangling.Owner.String(),
+				string(dangling.Reason),
+				strconv.FormatBool(orphan.Orphaned),
+			})
+		}
+	}
+	return util.PrintOutput(w, output, orphans, []string{"KIND", "NAMESPACE", "NAME", "ENCODING", "OWNER", "REASON", "ORPHANED"}, rows)
+}
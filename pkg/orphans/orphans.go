@@ -0,0 +1,339 @@
+/*
+Copyright 2020 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package orphans finds objects whose label or annotation based owners no longer exist,
+// or were recreated with the same name.
+package orphans
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"k8c.io/utils/pkg/multiowner"
+	"k8c.io/utils/pkg/owner"
+	"k8c.io/utils/pkg/util"
+)
+
+// Encoding describes how an owner is recorded on an object.
+type Encoding string
+
+const (
+	// EncodingLabels are the owner.kubermatic.io/* labels of the owner package.
+	EncodingLabels Encoding = "labels"
+	// EncodingAnnotation is the kubermatic.io/owner annotation of the multiowner package.
+	EncodingAnnotation Encoding = "annotation"
+)
+
+// Reason describes why an owner reference is dangling.
+type Reason string
+
+const (
+	// ReasonOwnerNotFound is used for owners that do not exist.
+	ReasonOwnerNotFound Reason = "OwnerNotFound"
+	// ReasonOwnerUIDMismatch is used for owners that were recreated with the same name,
+	// so the object belongs to the previous owner.
+	ReasonOwnerUIDMismatch Reason = "OwnerUIDMismatch"
+)
+
+// DanglingReference is an owner reference pointing to an owner that no longer exists.
+type DanglingReference struct {
+	Owner    util.ObjectReference `json:"owner"`
+	Encoding Encoding             `json:"encoding"`
+	Reason   Reason               `json:"reason"`
+}
+
+// Orphan is an object with at least one dangling owner reference.
+type Orphan struct {
+	Object util.ObjectReference `json:"object"`
+	// DanglingReferences lists all references to owners that no longer exist.
+	DanglingReferences []DanglingReference `json:"danglingReferences"`
+	// Orphaned is true, if none of the owners of the object exist anymore.
+	// References to a recreated owner with another UID are reported, but do not count as missing owners.
+	Orphaned bool `json:"orphaned"`
+
+	obj runtime.Object
+}
+
+// Finder finds objects with dangling owner references.
+//
+// Owners are only looked up in the cluster of Client,
+// objects labeled with the util.ClusterLabel of owners in another cluster are skipped.
+type Finder struct {
+	Client client.Client
+	Mapper meta.RESTMapper
+	Scheme *runtime.Scheme
+	Log    logr.Logger
+
+	// owners caches the existence of owners.
+	owners map[util.ObjectReference]ownerState
+}
+
+// ownerState is the cached state of an owner.
+type ownerState struct {
+	exists bool
+	uid    types.UID
+}
+
+// ListableTypes uses discovery to return type hints for all listable API resources.
+func ListableTypes(log logr.Logger, d discovery.DiscoveryInterface) ([]runtime.Object, error) {
+	resourceLists, err := d.ServerPreferredResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, fmt.Errorf("discovering API resources: %w", err)
+		}
+		// still use all the API groups that could be discovered.
+		log.Error(err, "discovering some API groups failed")
+	}
+
+	var objTypes []runtime.Object
+	for _, lst := range discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list"}}, resourceLists) {
+		gv, err := schema.ParseGroupVersion(lst.GroupVersion)
+		if err != nil {
+			return nil, fmt.Errorf("parsing group version %q: %w", lst.GroupVersion, err)
+		}
+		for _, resource := range lst.APIResources {
+			if strings.Contains(resource.Name, "/") {
+				// subresources are not listable on their own.
+				continue
+			}
+			obj := &unstructured.Unstructured{}
+			obj.SetGroupVersionKind(gv.WithKind(resource.Kind))
+			objTypes = append(objTypes, obj)
+		}
+	}
+	return objTypes, nil
+}
+
+// Find returns all objects of the given types with dangling owner references.
+func (f *Finder) Find(ctx context.Context, objTypes []runtime.Object, options ...client.ListOption) ([]Orphan, error) {
+	var orphans []Orphan
+	for _, objType := range objTypes {
+		objs, err := util.ListObjects(ctx, f.Client, f.Scheme, []runtime.Object{objType}, options...)
+		if err != nil {
+			// e.g. missing permissions for a single type should not stop the whole search.
+			f.Log.Error(err, "skipping type", "type", objType.GetObjectKind().GroupVersionKind().String())
+			continue
+		}
+
+		for _, obj := range objs {
+			orphan, found, err := f.check(ctx, obj)
+			if err != nil {
+				return nil, err
+			}
+			if found {
+				orphans = append(orphans, orphan)
+			}
+		}
+	}
+	return orphans, nil
+}
+
+// check reports whether the object has dangling owner references.
+func (f *Finder) check(ctx context.Context, obj runtime.Object) (orphan Orphan, found bool, err error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return orphan, false, err
+	}
+	if cluster := accessor.GetLabels()[util.ClusterLabel]; cluster != "" {
+		// owners in other clusters cannot be looked up with this client.
+		f.Log.V(1).Info("skipping object owned from another cluster", "object", util.ToObjectReference(obj, f.Scheme).String(), "cluster", cluster)
+		return orphan, false, nil
+	}
+
+	type ownerRef struct {
+		ref      util.ObjectReference
+		encoding Encoding
+		uid      types.UID
+	}
+	var refs []ownerRef
+	if owner.IsOwned(accessor) {
+		ref, _, err := owner.GetOwnerReference(accessor)
+		if err != nil {
+			return orphan, false, err
+		}
+		uid, _ := owner.GetOwnerUID(accessor)
+		refs = append(refs, ownerRef{ref: ref, encoding: EncodingLabels, uid: uid})
+	}
+	multiOwners, err := multiowner.GetOwners(accessor)
+	if err != nil {
+		f.Log.Error(err, "cannot parse owner annotation", "object", util.ToObjectReference(obj, f.Scheme).String())
+	}
+	for _, entry := range multiOwners {
+		refs = append(refs, ownerRef{ref: entry.ObjectReference, encoding: EncodingAnnotation, uid: entry.UID})
+	}
+	if len(refs) == 0 {
+		return orphan, false, nil
+	}
+
+	orphan.Object = util.ToObjectReference(obj, f.Scheme)
+	var missing int
+	for _, r := range refs {
+		state, err := f.ownerState(ctx, r.ref)
+		if err != nil {
+			return orphan, false, err
+		}
+		var reason Reason
+		switch {
+		case !state.exists:
+			reason = ReasonOwnerNotFound
+			missing++
+		case r.uid != "" && state.uid != "" && r.uid != state.uid:
+			reason = ReasonOwnerUIDMismatch
+		default:
+			continue
+		}
+		orphan.DanglingReferences = append(orphan.DanglingReferences, DanglingReference{
+			Owner:    r.ref,
+			Encoding: r.encoding,
+			Reason:   reason,
+		})
+	}
+	if len(orphan.DanglingReferences) == 0 {
+		return orphan, false, nil
+	}
+	orphan.Orphaned = missing == len(refs)
+
+	orphan.obj = obj.DeepCopyObject()
+	return orphan, true, nil
+}
+
+// ownerState checks whether the referenced owner exists.
+func (f *Finder) ownerState(ctx context.Context, ref util.ObjectReference) (ownerState, error) {
+	if f.owners == nil {
+		f.owners = map[util.ObjectReference]ownerState{}
+	}
+	if state, ok := f.owners[ref]; ok {
+		return state, nil
+	}
+
+	state, err := f.lookupOwner(ctx, ref)
+	if err != nil {
+		return state, err
+	}
+	f.owners[ref] = state
+	return state, nil
+}
+
+func (f *Finder) lookupOwner(ctx context.Context, ref util.ObjectReference) (ownerState, error) {
+	mapping, err := f.Mapper.RESTMapping(schema.GroupKind{Group: ref.Group, Kind: ref.Kind})
+	if meta.IsNoMatchError(err) {
+		// the owner type itself is gone.
+		return ownerState{}, nil
+	}
+	if err != nil {
+		return ownerState{}, fmt.Errorf("getting REST mapping for owner %s: %w", ref, err)
+	}
+
+	key := client.ObjectKey{Name: ref.Name, Namespace: ref.Namespace}
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		key.Namespace = ""
+	} else if key.Namespace == "" {
+		// a namespaced owner without namespace can never be found.
+		return ownerState{}, nil
+	}
+
+	ownerObj := &unstructured.Unstructured{}
+	ownerObj.SetGroupVersionKind(mapping.GroupVersionKind)
+	err = f.Client.Get(ctx, key, ownerObj)
+	switch {
+	case err == nil:
+		return ownerState{exists: true, uid: ownerObj.GetUID()}, nil
+	case errors.IsNotFound(err):
+		return ownerState{}, nil
+	default:
+		return ownerState{}, fmt.Errorf("getting owner %s: %w", ref, err)
+	}
+}
+
+// Delete deletes all fully orphaned objects.
+// Objects that still have existing owners are skipped, as are objects changed or recreated since they were found.
+func (f *Finder) Delete(ctx context.Context, orphans []Orphan) error {
+	for _, orphan := range orphans {
+		if !orphan.Orphaned {
+			continue
+		}
+		accessor, err := meta.Accessor(orphan.obj)
+		if err != nil {
+			return err
+		}
+		preconditions := client.Preconditions{}
+		if uid := accessor.GetUID(); uid != "" {
+			preconditions.UID = &uid
+		}
+		if resourceVersion := accessor.GetResourceVersion(); resourceVersion != "" {
+			preconditions.ResourceVersion = &resourceVersion
+		}
+		err = f.Client.Delete(ctx, orphan.obj, preconditions)
+		switch {
+		case err == nil:
+			f.Log.Info("deleted orphan", "object", orphan.Object.String())
+		case errors.IsNotFound(err):
+			f.Log.Info("orphan already gone", "object", orphan.Object.String())
+		case errors.IsConflict(err):
+			// the preconditions failed, the object may have been adopted meanwhile.
+			f.Log.Info("skipping orphan changed since it was found", "object", orphan.Object.String())
+		default:
+			return fmt.Errorf("deleting %s: %w", orphan.Object, err)
+		}
+	}
+	return nil
+}
+
+// Strip removes all references to owners that do not exist anymore from the orphans.
+// References to a recreated owner with another UID are left alone.
+func (f *Finder) Strip(ctx context.Context, orphans []Orphan) error {
+	for _, orphan := range orphans {
+		obj := orphan.obj
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		var stripped bool
+		for _, dangling := range orphan.DanglingReferences {
+			if dangling.Reason != ReasonOwnerNotFound {
+				continue
+			}
+			stripped = true
+			switch dangling.Encoding {
+			case EncodingLabels:
+				owner.RemoveOwnerReference(nil, obj)
+			case EncodingAnnotation:
+				if _, err := multiowner.DeleteObjectReference(dangling.Owner, accessor); err != nil {
+					return fmt.Errorf("removing owner %s from %s: %w", dangling.Owner, orphan.Object, err)
+				}
+			}
+		}
+		if !stripped {
+			continue
+		}
+		if err := f.Client.Update(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("updating %s: %w", orphan.Object, err)
+		}
+		f.Log.Info("stripped dangling owner references", "object", orphan.Object.String())
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2020 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orphans
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"k8c.io/utils/pkg/multiowner"
+	"k8c.io/utils/pkg/owner"
+	"k8c.io/utils/pkg/testutil"
+	"k8c.io/utils/pkg/util"
+)
+
+var (
+	testScheme     = runtime.NewScheme()
+	testRESTMapper = meta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion})
+)
+
+func init() {
+	// setup scheme for all tests
+	utilruntime.Must(corev1.AddToScheme(testScheme))
+
+	testRESTMapper.Add(corev1.SchemeGroupVersion.WithKind("Namespace"), meta.RESTScopeRoot)
+	testRESTMapper.Add(corev1.SchemeGroupVersion.WithKind("Secret"), meta.RESTScopeNamespace)
+	testRESTMapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+}
+
+func TestFinder(t *testing.T) {
+	liveOwner := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "live", Namespace: "default", UID: "live-uid"}}
+	goneOwner := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "gone", Namespace: "default"}}
+	goneClusterOwner := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "gone"}}
+
+	newConfigMap := func(name string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "other", ResourceVersion: "1"}}
+	}
+
+	labelOwned := newConfigMap("label-owned")
+	_, err := owner.SetOwnerReference(liveOwner, labelOwned, testScheme)
+	require.NoError(t, err)
+
+	labelOrphan := newConfigMap("label-orphan")
+	_, err = owner.SetOwnerReference(goneClusterOwner, labelOrphan, testScheme)
+	require.NoError(t, err)
+
+	annotationOrphan := newConfigMap("annotation-orphan")
+	_, err = multiowner.InsertOwnerReference(goneOwner, annotationOrphan, testScheme)
+	require.NoError(t, err)
+
+	partialOrphan := newConfigMap("partial-orphan")
+	_, err = multiowner.InsertOwnerReference(goneOwner, partialOrphan, testScheme)
+	require.NoError(t, err)
+	_, err = multiowner.InsertOwnerReference(liveOwner, partialOrphan, testScheme)
+	require.NoError(t, err)
+
+	previousOwnerOrphan := newConfigMap("previous-owner-orphan")
+	_, err = owner.SetOwnerReference(liveOwner, previousOwnerOrphan, testScheme)
+	require.NoError(t, err)
+	previousOwnerOrphan.Annotations[owner.OwnerUIDAnnotation] = "previous-uid"
+
+	unowned := newConfigMap("unowned")
+
+	// the owner lives in another cluster and cannot be looked up.
+	remoteOwned := newConfigMap("remote-owned")
+	_, err = owner.SetOwnerReference(goneOwner, remoteOwned, testScheme, owner.WithClusterName("remote"))
+	require.NoError(t, err)
+
+	newFinder := func(t *testing.T) (*Finder, client.Client) {
+		cl := fakeclient.NewFakeClientWithScheme(testScheme,
+			liveOwner.DeepCopy(), labelOwned.DeepCopy(), labelOrphan.DeepCopy(),
+			annotationOrphan.DeepCopy(), partialOrphan.DeepCopy(), previousOwnerOrphan.DeepCopy(), unowned.DeepCopy(), remoteOwned.DeepCopy())
+		return &Finder{
+			Client: cl,
+			Mapper: testRESTMapper,
+			Scheme: testScheme,
+			Log:    testutil.NewLogger(t),
+		}, cl
+	}
+	objTypes := []runtime.Object{&corev1.ConfigMap{}, &corev1.Secret{}}
+	ctx := context.Background()
+
+	t.Run("find", func(t *testing.T) {
+		finder, _ := newFinder(t)
+		orphans, err := finder.Find(ctx, objTypes)
+		require.NoError(t, err)
+
+		got := map[string]Orphan{}
+		for _, orphan := range orphans {
+			orphan.obj = nil
+			got[orphan.Object.Name] = orphan
+		}
+		assert.Equal(t, map[string]Orphan{
+			"label-orphan": {
+				Object: util.ToObjectReference(labelOrphan, testScheme),
+				DanglingReferences: []DanglingReference{
+					{Owner: util.ToObjectReference(goneClusterOwner, testScheme), Encoding: EncodingLabels, Reason: ReasonOwnerNotFound},
+				},
+				Orphaned: true,
+			},
+			"annotation-orphan": {
+				Object: util.ToObjectReference(annotationOrphan, testScheme),
+				DanglingReferences: []DanglingReference{
+					{Owner: util.ToObjectReference(goneOwner, testScheme), Encoding: EncodingAnnotation, Reason: ReasonOwnerNotFound},
+				},
+				Orphaned: true,
+			},
+			"partial-orphan": {
+				Object: util.ToObjectReference(partialOrphan, testScheme),
+				DanglingReferences: []DanglingReference{
+					{Owner: util.ToObjectReference(goneOwner, testScheme), Encoding: EncodingAnnotation, Reason: ReasonOwnerNotFound},
+				},
+				Orphaned: false,
+			},
+			"previous-owner-orphan": {
+				Object: util.ToObjectReference(previousOwnerOrphan, testScheme),
+				DanglingReferences: []DanglingReference{
+					{Owner: util.ToObjectReference(liveOwner, testScheme), Encoding: EncodingLabels, Reason: ReasonOwnerUIDMismatch},
+				},
+				Orphaned: false,
+			},
+		}, got)
+	})
+
+	t.Run("find unstructured", func(t *testing.T) {
+		finder, _ := newFinder(t)
+		cmType := &unstructured.Unstructured{}
+		cmType.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("ConfigMap"))
+		orphans, err := finder.Find(ctx, []runtime.Object{cmType})
+		require.NoError(t, err)
+		assert.Len(t, orphans, 4)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		finder, cl := newFinder(t)
+		orphans, err := finder.Find(ctx, objTypes)
+		require.NoError(t, err)
+		require.NoError(t, finder.Delete(ctx, orphans))
+
+		for name, exists := range map[string]bool{
+			"label-owned":           true,
+			"label-orphan":          false,
+			"annotation-orphan":     false,
+			"partial-orphan":        true,
+			"previous-owner-orphan": true,
+			"unowned":               true,
+		} {
+			err := cl.Get(ctx, client.ObjectKey{Name: name, Namespace: "other"}, &corev1.ConfigMap{})
+			if exists {
+				assert.NoError(t, err, name)
+			} else {
+				assert.True(t, errors.IsNotFound(err), name)
+			}
+		}
+	})
+
+	t.Run("delete changed orphans", func(t *testing.T) {
+		finder, cl := newFinder(t)
+		// enforce the delete preconditions, which the fake client ignores.
+		finder.Client = &testutil.InterceptingClient{Client: cl, DeleteFunc: testutil.DeleteWithPreconditions}
+		orphans, err := finder.Find(ctx, objTypes)
+		require.NoError(t, err)
+
+		// label-orphan is changed and annotation-orphan is gone, since they were found.
+		changed := &corev1.ConfigMap{}
+		require.NoError(t, cl.Get(ctx, client.ObjectKey{Name: "label-orphan", Namespace: "other"}, changed))
+		changed.Data = map[string]string{"changed": "true"}
+		require.NoError(t, cl.Update(ctx, changed))
+		require.NoError(t, cl.Delete(ctx, annotationOrphan.DeepCopy()))
+
+		require.NoError(t, finder.Delete(ctx, orphans))
+		assert.NoError(t, cl.Get(ctx, client.ObjectKey{Name: "label-orphan", Namespace: "other"}, &corev1.ConfigMap{}))
+	})
+
+	t.Run("strip", func(t *testing.T) {
+		finder, cl := newFinder(t)
+		orphans, err := finder.Find(ctx, objTypes)
+		require.NoError(t, err)
+		require.NoError(t, finder.Strip(ctx, orphans))
+
+		orphans, err = finder.Find(ctx, objTypes)
+		require.NoError(t, err)
+		require.Len(t, orphans, 1)
+		assert.Equal(t, "previous-owner-orphan", orphans[0].Object.Name)
+
+		// the reference to the recreated owner is kept.
+		cm := &corev1.ConfigMap{}
+		require.NoError(t, cl.Get(ctx, client.ObjectKey{Name: "previous-owner-orphan", Namespace: "other"}, cm))
+		assert.True(t, owner.IsOwned(cm))
+		uid, _ := owner.GetOwnerUID(cm)
+		assert.Equal(t, types.UID("previous-uid"), uid)
+
+		cm = &corev1.ConfigMap{}
+		require.NoError(t, cl.Get(ctx, client.ObjectKey{Name: "partial-orphan", Namespace: "other"}, cm))
+		refs, err := multiowner.GetOwnerReferences(cm)
+		require.NoError(t, err)
+		assert.Equal(t, []util.ObjectReference{util.ToObjectReference(liveOwner, testScheme)}, refs)
+	})
+}
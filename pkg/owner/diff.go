@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package owner
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ignoredDiffPaths are maintained by the API server and not part of the desired state.
+var ignoredDiffPaths = map[string]bool{
+	"apiVersion":                 true,
+	"kind":                       true,
+	"status":                     true,
+	"metadata.creationTimestamp": true,
+	"metadata.generation":        true,
+	"metadata.managedFields":     true,
+	"metadata.resourceVersion":   true,
+	"metadata.selfLink":          true,
+	"metadata.uid":               true,
+}
+
+// FieldDiff is a change of a single field.
+// Old is nil for added fields and New is nil for removed fields.
+type FieldDiff struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// Diff lists the changed fields of an object, sorted by path.
+type Diff []FieldDiff
+
+func (d Diff) String() string {
+	lines := make([]string, 0, len(d))
+	for _, f := range d {
+		lines = append(lines, fmt.Sprintf("%s: %s -> %s", f.Path, diffValue(f.Old), diffValue(f.New)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func diffValue(v interface{}) string {
+	if v == nil {
+		return "<none>"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
+
+// diffObjects returns the field level differences between the old and new object.
+// Maps are compared field by field, lists are compared as a whole.
+func diffObjects(old, new runtime.Object) (Diff, error) {
+	oldContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(old)
+	if err != nil {
+		return nil, fmt.Errorf("converting %T: %w", old, err)
+	}
+	newContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(new)
+	if err != nil {
+		return nil, fmt.Errorf("converting %T: %w", new, err)
+	}
+
+	var diff Diff
+	diffMaps("", oldContent, newContent, &diff)
+	sort.Slice(diff, func(i, j int) bool {
+		return diff[i].Path < diff[j].Path
+	})
+	return diff, nil
+}
+
+func diffMaps(prefix string, old, new map[string]interface{}, diff *Diff) {
+	keys := map[string]struct{}{}
+	for k := range old {
+		keys[k] = struct{}{}
+	}
+	for k := range new {
+		keys[k] = struct{}{}
+	}
+
+	for k := range keys {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		if ignoredDiffPaths[path] {
+			continue
+		}
+
+		oldValue, newValue := old[k], new[k]
+		oldMap, oldIsMap := oldValue.(map[string]interface{})
+		newMap, newIsMap := newValue.(map[string]interface{})
+		switch {
+		case oldIsMap && newIsMap:
+			diffMaps(path, oldMap, newMap, diff)
+		case oldIsMap && newValue == nil:
+			diffMaps(path, oldMap, nil, diff)
+		case newIsMap && oldValue == nil:
+			diffMaps(path, nil, newMap, diff)
+		case !reflect.DeepEqual(oldValue, newValue):
+			*diff = append(*diff, FieldDiff{Path: path, Old: oldValue, New: newValue})
+		}
+	}
+}
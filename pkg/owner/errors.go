@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package owner
+
+import (
+	"errors"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"k8c.io/utils/pkg/util"
+)
+
+// ErrMaxDeletionsExceeded is wrapped by the error returned, if more objects would be pruned than allowed by WithMaxDeletions.
+var ErrMaxDeletionsExceeded = errors.New("maximum number of deletions exceeded")
+
+// OwnershipConflictError is returned, if an object cannot be owned by the requested owner,
+// because it is owned by another owner or, unless adopted, not owned at all.
+type OwnershipConflictError struct {
+	// Object is the conflicting object.
+	Object util.ObjectReference
+	// Existing is the current owner of the object, empty if the object is not owned.
+	Existing util.ObjectReference
+	// Requested is the owner that should own the object.
+	Requested util.ObjectReference
+}
+
+func (e *OwnershipConflictError) Error() string {
+	if e.Existing == (util.ObjectReference{}) {
+		return fmt.Sprintf("%s is not owned and must not be adopted by %s", e.Object, e.Requested)
+	}
+	existingLabels, wantedLabels := plainLabels(e.Existing), plainLabels(e.Requested)
+	for _, k := range ownerLabelKeys {
+		if existingLabels[k] != wantedLabels[k] {
+			return fmt.Sprintf("tried to override owner reference: %s=%s to =%s", k, existingLabels[k], wantedLabels[k])
+		}
+	}
+	return fmt.Sprintf("tried to override owner reference %s to %s", e.Existing, e.Requested)
+}
+
+// IsOwnershipConflict reports whether err is or wraps an OwnershipConflictError.
+func IsOwnershipConflict(err error) bool {
+	return asOwnershipConflict(err) != nil
+}
+
+// asOwnershipConflict returns the OwnershipConflictError err is or wraps, or nil.
+func asOwnershipConflict(err error) *OwnershipConflictError {
+	var conflict *OwnershipConflictError
+	if errors.As(err, &conflict) {
+		return conflict
+	}
+	return nil
+}
+
+// OwnerUIDMismatchError is returned, if an object records another owner UID than the one of its current owner.
+// This happens, when an owner was deleted and recreated with the same name.
+type OwnerUIDMismatchError struct {
+	// Object is the owned object.
+	Object util.ObjectReference
+	// Owner is the owner recorded on the object.
+	Owner util.ObjectReference
+	// Recorded is the owner UID recorded on the object.
+	Recorded types.UID
+	// Current is the UID of the current owner.
+	Current types.UID
+}
+
+func (e *OwnerUIDMismatchError) Error() string {
+	return fmt.Sprintf("%s belongs to owner %s with UID %s, but the owner has UID %s", e.Object, e.Owner, e.Recorded, e.Current)
+}
+
+// IsOwnerUIDMismatch reports whether err is or wraps an OwnerUIDMismatchError.
+func IsOwnerUIDMismatch(err error) bool {
+	var mismatch *OwnerUIDMismatchError
+	return errors.As(err, &mismatch)
+}
+
+// ReconcileError aggregates the errors of the single objects of a ReconcileOwnedObjects run.
+// errors.Is and errors.As match any of the aggregated errors.
+type ReconcileError struct {
+	Errors []error
+}
+
+func (e *ReconcileError) Error() string {
+	return utilerrors.NewAggregate(e.Errors).Error()
+}
+
+// Is reports whether any of the aggregated errors matches target.
+func (e *ReconcileError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first aggregated error matching target.
+func (e *ReconcileError) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,280 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package owner
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"k8c.io/utils/pkg/util"
+)
+
+const (
+	// GarbageCollectorFinalizer is added to owners that need their owned objects
+	// processed before they are gone, which is the case for Foreground and Orphan propagation.
+	GarbageCollectorFinalizer = "owner.kubermatic.io/garbage-collector"
+	// PropagationPolicyAnnotation overrides the propagation policy of the GarbageCollector for a single owner.
+	// Valid values are Foreground, Background and Orphan.
+	PropagationPolicyAnnotation = "owner.kubermatic.io/propagation-policy"
+
+	// foregroundRequeueInterval is the interval at which owned objects are checked during foreground deletion.
+	foregroundRequeueInterval = 5 * time.Second
+)
+
+// GarbageCollector deletes objects labeled as owned by an owner, once the owner is deleted or missing.
+//
+// It mimics the native Kubernetes garbage collector for label based ownership:
+//   - Background: owned objects are deleted after the owner is gone.
+//   - Foreground: the owner is kept until all owned objects are deleted.
+//   - Orphan: the owner labels are removed from owned objects, before the owner is gone.
+//
+// The GarbageCollector expects owners and owned objects to live in the same cluster.
+// Owned objects recording the UID of a previous owner with the same name are reported, but not collected.
+type GarbageCollector struct {
+	// Client is used to access owners and owned objects.
+	Client client.Client
+	// APIReader is used to confirm an owner is really gone, before owned objects are deleted.
+	// Defaults to Client.
+	APIReader client.Reader
+	Log       logr.Logger
+	Scheme    *runtime.Scheme
+
+	// OwnerType is the type of owners to watch.
+	OwnerType runtime.Object
+	// OwnedTypes are the types of objects to collect.
+	OwnedTypes []runtime.Object
+	// PropagationPolicy is used for owners without PropagationPolicyAnnotation.
+	// Defaults to Background.
+	PropagationPolicy metav1.DeletionPropagation
+	// Options are used to select and watch owned objects.
+	Options []Option
+}
+
+// SetupWithManager registers the GarbageCollector with the given manager.
+func (gc *GarbageCollector) SetupWithManager(mgr ctrl.Manager) error {
+	if gc.APIReader == nil {
+		gc.APIReader = mgr.GetAPIReader()
+	}
+	if gc.Log == nil {
+		gc.Log = ctrl.Log.WithName("owner-garbage-collector")
+	}
+	b, err := util.NewGarbageCollectorBuilder(mgr, gc.Scheme, "owner-garbage-collector", gc.OwnerType, gc.OwnedTypes,
+		EnqueueRequestForOwner(gc.OwnerType, gc.Scheme, gc.Options...))
+	if err != nil {
+		return err
+	}
+	return b.Complete(gc)
+}
+
+// Reconcile collects the owned objects of the requested owner.
+func (gc *GarbageCollector) Reconcile(req reconcile.Request) (reconcile.Result, error) {
+	ctx := context.Background()
+	log := gc.Log.WithValues("owner", req.NamespacedName.String())
+	o := buildOptions(gc.Options)
+
+	ownerGVK, err := apiutil.GVKForObject(gc.OwnerType, gc.Scheme)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("cannot get GVK for %T: %w", gc.OwnerType, err)
+	}
+	ownerObj, err := util.NewObject(gc.OwnerType, gc.Scheme)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	ownerRef := util.ObjectReference{
+		Name:      req.Name,
+		Namespace: req.Namespace,
+		Group:     ownerGVK.Group,
+		Kind:      ownerGVK.Kind,
+	}
+
+	err = util.GetOwner(ctx, gc.Client, gc.APIReader, req, ownerObj)
+	switch {
+	case errors.IsNotFound(err):
+		_, err := gc.deleteOwnedObjects(ctx, log, ownerRef, o, metav1.DeletePropagationBackground)
+		return reconcile.Result{}, err
+	case err != nil:
+		return reconcile.Result{}, fmt.Errorf("getting owner: %w", err)
+	}
+
+	ownerAccessor, err := meta.Accessor(ownerObj)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	policy := gc.propagationPolicy(log, ownerAccessor)
+
+	if ownerAccessor.GetDeletionTimestamp().IsZero() {
+		if err := gc.reportUIDMismatches(ctx, log, ownerObj, ownerRef, o); err != nil {
+			return reconcile.Result{}, err
+		}
+
+		// foreground and orphan propagation need to process owned objects, before the owner is gone.
+		var changed bool
+		if policy == metav1.DeletePropagationBackground {
+			changed = util.RemoveFinalizer(ownerAccessor, GarbageCollectorFinalizer)
+		} else {
+			changed = util.AddFinalizer(ownerAccessor, GarbageCollectorFinalizer)
+		}
+		if changed {
+			if err := gc.Client.Update(ctx, ownerObj); err != nil {
+				return reconcile.Result{}, fmt.Errorf("updating owner finalizers: %w", err)
+			}
+		}
+		return reconcile.Result{}, nil
+	}
+
+	if !hasFinalizer(ownerAccessor, GarbageCollectorFinalizer) {
+		// background propagation, owned objects are deleted once the owner is gone.
+		return reconcile.Result{}, nil
+	}
+
+	switch policy {
+	case metav1.DeletePropagationOrphan:
+		if err := gc.orphanOwnedObjects(ctx, log, ownerRef, o); err != nil {
+			return reconcile.Result{}, err
+		}
+	case metav1.DeletePropagationForeground:
+		remaining, err := gc.deleteOwnedObjects(ctx, log, ownerRef, o, metav1.DeletePropagationForeground)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if remaining {
+			return reconcile.Result{RequeueAfter: foregroundRequeueInterval}, nil
+		}
+	}
+
+	if util.RemoveFinalizer(ownerAccessor, GarbageCollectorFinalizer) {
+		if err := gc.Client.Update(ctx, ownerObj); err != nil {
+			return reconcile.Result{}, fmt.Errorf("removing owner finalizer: %w", err)
+		}
+	}
+	return reconcile.Result{}, nil
+}
+
+// propagationPolicy returns the propagation policy for the given owner.
+func (gc *GarbageCollector) propagationPolicy(log logr.Logger, owner metav1.Object) metav1.DeletionPropagation {
+	policy := gc.PropagationPolicy
+	if value, ok := owner.GetAnnotations()[PropagationPolicyAnnotation]; ok {
+		policy = metav1.DeletionPropagation(value)
+	}
+	switch policy {
+	case metav1.DeletePropagationForeground, metav1.DeletePropagationBackground, metav1.DeletePropagationOrphan:
+		return policy
+	case "":
+		return metav1.DeletePropagationBackground
+	default:
+		log.Info("unknown propagation policy, falling back to Background", "policy", policy)
+		return metav1.DeletePropagationBackground
+	}
+}
+
+// reportUIDMismatches logs owned objects, which belong to a previous owner with the same name.
+// They are not collected, as the current owner may adopt them.
+func (gc *GarbageCollector) reportUIDMismatches(ctx context.Context, log logr.Logger, ownerObj runtime.Object, ownerRef util.ObjectReference, o *options) error {
+	owned, err := listOwnedObjects(ctx, gc.Client, gc.Scheme, ownerRef, gc.OwnedTypes, o)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range owned {
+		err := CheckOwnerUID(ownerObj, obj, gc.Scheme)
+		var mismatch *OwnerUIDMismatchError
+		switch {
+		case goerrors.As(err, &mismatch):
+			log.Info("owned object belongs to a previous owner with the same name",
+				"object", util.MustLogLine(obj, gc.Scheme), "recordedUID", mismatch.Recorded, "ownerUID", mismatch.Current)
+		case err != nil:
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteOwnedObjects deletes all objects owned by the referenced owner.
+// It reports whether owned objects remain, which are still in deletion.
+func (gc *GarbageCollector) deleteOwnedObjects(ctx context.Context, log logr.Logger, ownerRef util.ObjectReference, o *options, policy metav1.DeletionPropagation) (remaining bool, err error) {
+	owned, err := listOwnedObjects(ctx, gc.Client, gc.Scheme, ownerRef, gc.OwnedTypes, o)
+	if err != nil {
+		return false, err
+	}
+
+	for _, obj := range owned {
+		remaining = true
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return false, err
+		}
+		if !accessor.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+
+		err = gc.Client.Delete(ctx, obj, client.PropagationPolicy(policy))
+		switch {
+		case err == nil:
+			log.V(6).Info("owned object deleted", "object", util.MustLogLine(obj, gc.Scheme))
+		case errors.IsNotFound(err):
+		default:
+			return false, fmt.Errorf("deleting %s: %w", util.MustLogLine(obj, gc.Scheme), err)
+		}
+	}
+	return remaining, nil
+}
+
+// orphanOwnedObjects removes the owner labels from all objects owned by the referenced owner.
+// With WithNativeOwnerReference, their metav1.OwnerReferences to the owner are removed as well.
+func (gc *GarbageCollector) orphanOwnedObjects(ctx context.Context, log logr.Logger, ownerRef util.ObjectReference, o *options) error {
+	owned, err := listOwnedObjects(ctx, gc.Client, gc.Scheme, ownerRef, gc.OwnedTypes, o)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range owned {
+		if !RemoveOwnerReference(nil, obj, gc.Options...) {
+			continue
+		}
+		err := gc.Client.Update(ctx, obj)
+		switch {
+		case err == nil:
+			log.V(6).Info("owned object orphaned", "object", util.MustLogLine(obj, gc.Scheme))
+		case errors.IsNotFound(err):
+		default:
+			return fmt.Errorf("orphaning %s: %w", util.MustLogLine(obj, gc.Scheme), err)
+		}
+	}
+	return nil
+}
+
+func hasFinalizer(object metav1.Object, finalizer string) bool {
+	for _, f := range object.GetFinalizers() {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package owner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"k8c.io/utils/pkg/testutil"
+)
+
+func TestGarbageCollector(t *testing.T) {
+	now := metav1.Now()
+	ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      "owner",
+		Namespace: "default",
+	}}
+	otherOwner := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      "other",
+		Namespace: "default",
+	}}
+
+	owned := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name:      "owned",
+		Namespace: "other-namespace",
+	}}
+	_, err := SetOwnerReference(ownerObj, owned, testScheme)
+	require.NoError(t, err)
+	owned.Annotations = map[string]string{OwnerUIDAnnotation: "previous-uid"}
+	notOwned := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name:      "not-owned",
+		Namespace: "other-namespace",
+	}}
+	_, err = SetOwnerReference(otherOwner, notOwned, testScheme)
+	require.NoError(t, err)
+
+	for name, testCase := range map[string]struct {
+		owner           *corev1.Secret
+		policy          metav1.DeletionPropagation
+		reconciles      int
+		ownedExists     bool
+		ownedIsOwned    bool
+		ownerFinalizers []string
+	}{
+		"missing owner": {
+			reconciles:  1,
+			ownedExists: false,
+		},
+		"live owner background": {
+			owner:        ownerObj.DeepCopy(),
+			reconciles:   1,
+			ownedExists:  true,
+			ownedIsOwned: true,
+		},
+		"recreated owner": {
+			owner: func() *corev1.Secret {
+				o := ownerObj.DeepCopy()
+				o.UID = "current-uid"
+				return o
+			}(),
+			reconciles:   1,
+			ownedExists:  true,
+			ownedIsOwned: true,
+		},
+		"live owner foreground": {
+			owner:           ownerObj.DeepCopy(),
+			policy:          metav1.DeletePropagationForeground,
+			reconciles:      1,
+			ownedExists:     true,
+			ownedIsOwned:    true,
+			ownerFinalizers: []string{GarbageCollectorFinalizer},
+		},
+		"deleting owner background": {
+			owner: func() *corev1.Secret {
+				o := ownerObj.DeepCopy()
+				o.DeletionTimestamp = &now
+				o.Finalizers = []string{"other"}
+				return o
+			}(),
+			reconciles:      1,
+			ownedExists:     true,
+			ownedIsOwned:    true,
+			ownerFinalizers: []string{"other"},
+		},
+		"deleting owner foreground": {
+			owner: func() *corev1.Secret {
+				o := ownerObj.DeepCopy()
+				o.DeletionTimestamp = &now
+				o.Finalizers = []string{GarbageCollectorFinalizer}
+				return o
+			}(),
+			policy:      metav1.DeletePropagationForeground,
+			reconciles:  2,
+			ownedExists: false,
+		},
+		"deleting owner orphan by annotation": {
+			owner: func() *corev1.Secret {
+				o := ownerObj.DeepCopy()
+				o.DeletionTimestamp = &now
+				o.Finalizers = []string{GarbageCollectorFinalizer}
+				o.Annotations = map[string]string{PropagationPolicyAnnotation: string(metav1.DeletePropagationOrphan)}
+				return o
+			}(),
+			policy:       metav1.DeletePropagationForeground,
+			reconciles:   1,
+			ownedExists:  true,
+			ownedIsOwned: false,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			objs := []runtime.Object{owned.DeepCopy(), notOwned.DeepCopy(), otherOwner.DeepCopy()}
+			if testCase.owner != nil {
+				objs = append(objs, testCase.owner)
+			}
+			cl := fakeclient.NewFakeClientWithScheme(testScheme, objs...)
+			gc := &GarbageCollector{
+				Client:            cl,
+				Log:               testutil.NewLogger(t),
+				Scheme:            testScheme,
+				OwnerType:         &corev1.Secret{},
+				OwnedTypes:        []runtime.Object{&corev1.ConfigMap{}},
+				PropagationPolicy: testCase.policy,
+			}
+
+			for i := 0; i < testCase.reconciles; i++ {
+				_, err := gc.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{
+					Name:      ownerObj.Name,
+					Namespace: ownerObj.Namespace,
+				}})
+				require.NoError(t, err)
+			}
+
+			cm := &corev1.ConfigMap{}
+			err := cl.Get(ctx, client.ObjectKey{Name: owned.Name, Namespace: owned.Namespace}, cm)
+			if testCase.ownedExists {
+				require.NoError(t, err)
+				assert.Equal(t, testCase.ownedIsOwned, IsOwned(cm))
+			} else {
+				assert.True(t, errors.IsNotFound(err), "owned object should be deleted")
+			}
+			require.NoError(t, cl.Get(ctx, client.ObjectKey{Name: notOwned.Name, Namespace: notOwned.Namespace}, cm), "object of other owner should be kept")
+
+			if testCase.owner != nil {
+				secret := &corev1.Secret{}
+				require.NoError(t, cl.Get(ctx, client.ObjectKey{Name: ownerObj.Name, Namespace: ownerObj.Namespace}, secret))
+				assert.Equal(t, testCase.ownerFinalizers, secret.Finalizers)
+			}
+		})
+	}
+}
+
+func TestGarbageCollector_OrphanNativeOwnerReference(t *testing.T) {
+	now := metav1.Now()
+	ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:              "owner",
+		Namespace:         "default",
+		UID:               "owner-uid",
+		DeletionTimestamp: &now,
+		Finalizers:        []string{GarbageCollectorFinalizer},
+	}}
+	opts := []Option{WithNativeOwnerReference(false, false)}
+
+	owned := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name:      "owned",
+		Namespace: "default",
+	}}
+	_, err := SetOwnerReference(ownerObj, owned, testScheme, opts...)
+	require.NoError(t, err)
+	require.Len(t, owned.OwnerReferences, 1)
+
+	ctx := context.Background()
+	cl := fakeclient.NewFakeClientWithScheme(testScheme, ownerObj, owned)
+	gc := &GarbageCollector{
+		Client:            cl,
+		Log:               testutil.NewLogger(t),
+		Scheme:            testScheme,
+		OwnerType:         &corev1.Secret{},
+		OwnedTypes:        []runtime.Object{&corev1.ConfigMap{}},
+		Options:           opts,
+		PropagationPolicy: metav1.DeletePropagationOrphan,
+	}
+	_, err = gc.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{
+		Name:      ownerObj.Name,
+		Namespace: ownerObj.Namespace,
+	}})
+	require.NoError(t, err)
+
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, cl.Get(ctx, client.ObjectKey{Name: owned.Name, Namespace: owned.Namespace}, cm))
+	assert.False(t, IsOwned(cm))
+	assert.Empty(t, cm.OwnerReferences, "the Kubernetes garbage collector must not delete the orphaned object")
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package owner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DesiredStateHashAnnotation records a hash of the desired object an owned object was last written with,
+// see WithDesiredStateHash.
+const DesiredStateHashAnnotation = "owner.kubermatic.io/desired-state-hash"
+
+// errUpToDate skips writing an object, which is up to date with its desired state.
+var errUpToDate = errors.New("object is up to date")
+
+// desiredStateHash returns a digest of the desired object.
+func desiredStateHash(desired runtime.Object) (string, error) {
+	obj := desired.DeepCopyObject()
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return "", err
+	}
+	if annotations := accessor.GetAnnotations(); annotations != nil {
+		delete(annotations, DesiredStateHashAnnotation)
+		accessor.SetAnnotations(annotations)
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return "", fmt.Errorf("marshalling %T: %w", desired, err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:20]), nil
+}
+
+// setDesiredStateHash records hash in the DesiredStateHashAnnotation of obj.
+func setDesiredStateHash(obj runtime.Object, hash string) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	annotations := accessor.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[DesiredStateHashAnnotation] = hash
+	accessor.SetAnnotations(annotations)
+	return nil
+}
+
+// upToDate reports whether the live object was last written with the desired state of hash,
+// and did not drift from desired meanwhile.
+//
+// An object drifted, if any field set in desired has another value in live.
+// Fields only set in live, e.g. defaulted by the API server, are ignored.
+func upToDate(live, desired runtime.Object, hash string) (bool, error) {
+	accessor, err := meta.Accessor(live)
+	if err != nil {
+		return false, err
+	}
+	if accessor.GetAnnotations()[DesiredStateHashAnnotation] != hash {
+		return false, nil
+	}
+
+	liveContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(live)
+	if err != nil {
+		return false, fmt.Errorf("converting %T: %w", live, err)
+	}
+	desiredContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desired)
+	if err != nil {
+		return false, fmt.Errorf("converting %T: %w", desired, err)
+	}
+	// the status is not written through updates.
+	delete(desiredContent, "status")
+	return containsFields(liveContent, desiredContent), nil
+}
+
+// containsFields reports whether all fields set in desired have the same value in live.
+// Lists must have the same length, their items are compared one by one.
+func containsFields(live, desired interface{}) bool {
+	switch d := desired.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		if len(d) == 0 {
+			return true
+		}
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, v := range d {
+			if !containsFields(l[k], v) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		l, _ := live.([]interface{})
+		if len(l) != len(d) {
+			return false
+		}
+		for i := range d {
+			if !containsFields(l[i], d[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(live, desired)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package owner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"k8c.io/utils/pkg/testutil"
+)
+
+func TestContainsFields(t *testing.T) {
+	for name, tc := range map[string]struct {
+		live, desired interface{}
+		want          bool
+	}{
+		"equal":          {live: map[string]interface{}{"a": "b"}, desired: map[string]interface{}{"a": "b"}, want: true},
+		"defaulted":      {live: map[string]interface{}{"a": "b", "c": int64(1)}, desired: map[string]interface{}{"a": "b"}, want: true},
+		"changed":        {live: map[string]interface{}{"a": "c"}, desired: map[string]interface{}{"a": "b"}, want: false},
+		"missing":        {live: map[string]interface{}{}, desired: map[string]interface{}{"a": "b"}, want: false},
+		"unset":          {live: map[string]interface{}{"a": "b"}, desired: map[string]interface{}{"a": nil, "c": map[string]interface{}{}}, want: true},
+		"list item":      {live: []interface{}{map[string]interface{}{"a": "b", "c": "d"}}, desired: []interface{}{map[string]interface{}{"a": "b"}}, want: true},
+		"list length":    {live: []interface{}{"a", "b"}, desired: []interface{}{"a"}, want: false},
+		"type mismatch":  {live: "a", desired: map[string]interface{}{"a": "b"}, want: false},
+		"nested changed": {live: map[string]interface{}{"a": map[string]interface{}{"b": int64(1)}}, desired: map[string]interface{}{"a": map[string]interface{}{"b": int64(2)}}, want: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, containsFields(tc.live, tc.desired))
+		})
+	}
+}
+
+func TestReconcileOwnedObjects_DesiredStateHash(t *testing.T) {
+	ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "default"}}
+	ctx := context.Background()
+	cmGVK := corev1.SchemeGroupVersion.WithKind("ConfigMap")
+	objectTypes := []runtime.Object{&corev1.ConfigMap{}}
+
+	newDesired := func(value string) []runtime.Object {
+		return []runtime.Object{&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"},
+			Data:       map[string]string{"key": value},
+		}}
+	}
+	// updateFn replaces the data, dropping defaulted keys like naive update functions do.
+	updateFn := func(actual, desired runtime.Object) error {
+		actual.(*corev1.ConfigMap).Data = desired.(*corev1.ConfigMap).Data
+		return nil
+	}
+	// newClient defaults a data key of ConfigMaps like the API server defaults fields, and counts updates.
+	newClient := func() (cl *testutil.InterceptingClient, updates *int) {
+		updates = new(int)
+		setDefaults := func(obj runtime.Object) {
+			if cm, ok := obj.(*corev1.ConfigMap); ok {
+				if cm.Data == nil {
+					cm.Data = map[string]string{}
+				}
+				if _, ok := cm.Data["defaulted"]; !ok {
+					cm.Data["defaulted"] = "true"
+				}
+			}
+		}
+		return &testutil.InterceptingClient{
+			Client: fakeclient.NewFakeClientWithScheme(testScheme, ownerObj),
+			CreateFunc: func(ctx context.Context, cl client.Client, obj runtime.Object, opts ...client.CreateOption) error {
+				setDefaults(obj)
+				return cl.Create(ctx, obj, opts...)
+			},
+			UpdateFunc: func(ctx context.Context, cl client.Client, obj runtime.Object, opts ...client.UpdateOption) error {
+				*updates++
+				setDefaults(obj)
+				return cl.Update(ctx, obj, opts...)
+			},
+		}, updates
+	}
+	reconcile := func(t *testing.T, cl client.Client, desired []runtime.Object, opts ...Option) *TypeResult {
+		result, err := ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, desired, objectTypes, updateFn, opts...)
+		require.NoError(t, err)
+		return result.For(cmGVK)
+	}
+
+	t.Run("without hash", func(t *testing.T) {
+		cl, updates := newClient()
+		reconcile(t, cl, newDesired("a"))
+		reconcile(t, cl, newDesired("a"))
+		assert.Equal(t, 1, *updates, "defaulted fields cause updates")
+	})
+
+	t.Run("with hash", func(t *testing.T) {
+		cl, updates := newClient()
+		opt := WithDesiredStateHash()
+		assert.Equal(t, 1, reconcile(t, cl, newDesired("a"), opt).Created)
+
+		cm := &corev1.ConfigMap{}
+		require.NoError(t, cl.Get(ctx, client.ObjectKey{Name: "cm", Namespace: "default"}, cm))
+		assert.NotEmpty(t, cm.Annotations[DesiredStateHashAnnotation])
+
+		result := reconcile(t, cl, newDesired("a"), opt)
+		assert.Equal(t, &TypeResult{GroupVersionKind: cmGVK, WriteSkipped: 1}, result)
+		assert.Equal(t, 0, *updates)
+
+		// drifted objects are updated.
+		cm.Data["key"] = "drifted"
+		require.NoError(t, cl.Client.Update(ctx, cm))
+		assert.Equal(t, 1, reconcile(t, cl, newDesired("a"), opt).Updated)
+		assert.Equal(t, 1, reconcile(t, cl, newDesired("a"), opt).WriteSkipped)
+
+		// changed desired states are updated.
+		assert.Equal(t, 1, reconcile(t, cl, newDesired("b"), opt).Updated)
+		assert.Equal(t, 2, *updates)
+
+		plan, err := PlanOwnedObjects(ctx, cl, testScheme, ownerObj, newDesired("b"), objectTypes, updateFn, opt)
+		require.NoError(t, err)
+		assert.True(t, plan.Empty())
+	})
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package owner
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"k8c.io/utils/pkg/util"
+)
+
+type options struct {
+	hashedLabels bool
+	restMapper   meta.RESTMapper
+
+	fieldManager   string
+	forceConflicts bool
+
+	adoptPolicy   AdoptPolicy
+	skipConflicts bool
+
+	maxDeletions      *int
+	propagationPolicy metav1.DeletionPropagation
+
+	uidCheckReader client.Reader
+
+	eventRecorder record.EventRecorder
+
+	workers int
+
+	nativeOwnerReference bool
+	controller           bool
+	blockOwnerDeletion   bool
+
+	clusterName string
+
+	desiredStateHash bool
+
+	recreateTypes       []runtime.Object
+	recreateWaiter      NotFoundWaiter
+	recreateWaitOptions []util.ClientWatcherOption
+}
+
+// AdoptPolicy controls whether ReconcileOwnedObjects takes over existing objects, which are not owned by anyone.
+type AdoptPolicy string
+
+const (
+	// AdoptNever fails with an OwnershipConflictError for existing objects without owner,
+	// and with an OwnerUIDMismatchError for objects of a previous owner with the same name. This is the default.
+	AdoptNever AdoptPolicy = "Never"
+	// AdoptUnowned makes existing objects without owner owned, if their names match desired objects.
+	// Objects of a previous owner with the same name, but another UID, are adopted as well.
+	AdoptUnowned AdoptPolicy = "Unowned"
+)
+
+// Option configures how owner references are written and read.
+type Option func(*options)
+
+// WithHashedLabels stores a digest of the owner in the owner labels,
+// and the full owner reference in the OwnerReferenceAnnotation.
+//
+// Use this mode if owner names, namespaces or types can exceed the 63 character label value limit.
+// Objects carrying plain owner labels are still recognized and are migrated once SetOwnerReference is called on them.
+func WithHashedLabels() Option {
+	return func(o *options) {
+		o.hashedLabels = true
+	}
+}
+
+// WithRESTMapper uses the given RESTMapper to determine whether an owner is cluster-scoped.
+//
+// With a RESTMapper, the namespace of cluster-scoped owners is ignored and their owner labels omit the namespace label,
+// and namespaced owners without a namespace are rejected.
+// Without a RESTMapper, the scope is unknown, so the namespace label is always set, empty for owners without a namespace.
+// Thus objects of cluster-scoped owners must be written and selected consistently with or without a RESTMapper.
+func WithRESTMapper(mapper meta.RESTMapper) Option {
+	return func(o *options) {
+		o.restMapper = mapper
+	}
+}
+
+// WithServerSideApply makes ReconcileOwnedObjects send desired objects as server-side apply patches,
+// instead of using CreateOrUpdate. fieldManager identifies the applier and is required.
+//
+// The updateFunc is not called in this mode, the API server merges desired and existing objects instead.
+func WithServerSideApply(fieldManager string) Option {
+	return func(o *options) {
+		o.fieldManager = fieldManager
+	}
+}
+
+// WithForceConflicts takes ownership of fields managed by other field managers, in server-side apply mode.
+func WithForceConflicts() Option {
+	return func(o *options) {
+		o.forceConflicts = true
+	}
+}
+
+// WithAdoptPolicy sets the AdoptPolicy of ReconcileOwnedObjects.
+// Objects owned by other owners are never adopted, use TransferOwnership to move them.
+// SetOwnerReference only replaces the recorded UID of a previous owner with AdoptUnowned as well.
+func WithAdoptPolicy(policy AdoptPolicy) Option {
+	return func(o *options) {
+		o.adoptPolicy = policy
+	}
+}
+
+// WithSkipConflicts makes ReconcileOwnedObjects skip desired objects, which cannot be owned due to an OwnershipConflictError,
+// instead of failing them. All other objects are still reconciled.
+// The conflicts are reported as skipped objects, see ReconcileResult.Conflicts,
+// and PlanOwnedObjects leaves conflicting objects out of the plan.
+func WithSkipConflicts() Option {
+	return func(o *options) {
+		o.skipConflicts = true
+	}
+}
+
+// WithMaxDeletions limits the number of objects ReconcileOwnedObjects may prune in a single run.
+// If more objects would be deleted, nothing is deleted and an error wrapping ErrMaxDeletionsExceeded is returned.
+// This guards against wiping all owned objects, because of a bug producing an empty desired state.
+func WithMaxDeletions(max int) Option {
+	return func(o *options) {
+		o.maxDeletions = &max
+	}
+}
+
+// WithPropagationPolicy sets the propagation policy for objects pruned by ReconcileOwnedObjects.
+// Defaults to the default policy of the object type.
+func WithPropagationPolicy(policy metav1.DeletionPropagation) Option {
+	return func(o *options) {
+		o.propagationPolicy = policy
+	}
+}
+
+// WithOwnerUIDCheck makes EnqueueRequestForOwner look up owners through reader,
+// and report owned objects recording another UID than the current owner.
+func WithOwnerUIDCheck(reader client.Reader) Option {
+	return func(o *options) {
+		o.uidCheckReader = reader
+	}
+}
+
+// WithEventRecorder makes ReconcileOwnedObjects record an Event on the owner for every changed, skipped or failed object.
+func WithEventRecorder(recorder record.EventRecorder) Option {
+	return func(o *options) {
+		o.eventRecorder = recorder
+	}
+}
+
+// WithConcurrency makes ReconcileOwnedObjects create, update and delete up to workers objects in parallel.
+// Objects are still processed group by group in apply order, so dependencies like Namespaces come first.
+// The updateFunc must be safe for concurrent use.
+func WithConcurrency(workers int) Option {
+	return func(o *options) {
+		o.workers = workers
+	}
+}
+
+// WithNativeOwnerReference makes SetOwnerReference also maintain a metav1.OwnerReference,
+// so the Kubernetes garbage collector and tools walking ownerReferences see the relation too.
+//
+// The native reference is only set for owners in the namespace of the object and cluster-scoped owners,
+// cross-namespace relations are recorded in the owner labels only. Owners without UID are skipped as well,
+// just like owners in other clusters configured with WithClusterName.
+// With this option, RemoveOwnerReference removes the native reference to the labeled owner.
+func WithNativeOwnerReference(controller, blockOwnerDeletion bool) Option {
+	return func(o *options) {
+		o.nativeOwnerReference = true
+		o.controller = controller
+		o.blockOwnerDeletion = blockOwnerDeletion
+	}
+}
+
+// WithClusterName makes SetOwnerReference label owned objects with the name of the cluster of the owner,
+// for objects in another cluster watched with util.WatchRemote. OwnedBy only matches objects labeled with this cluster.
+// SetOwnerReference fails for objects labeled with another cluster.
+func WithClusterName(clusterName string) Option {
+	return func(o *options) {
+		o.clusterName = clusterName
+	}
+}
+
+// WithDesiredStateHash makes ReconcileOwnedObjects record a hash of each desired object in the DesiredStateHashAnnotation,
+// and skip writing existing objects, which were last written with the same desired state and did not drift.
+// An object drifted, if a field set in the desired object has another value in the live object.
+// The updateFunc is not called for skipped objects, they are counted in TypeResult.WriteSkipped.
+func WithDesiredStateHash() Option {
+	return func(o *options) {
+		o.desiredStateHash = true
+	}
+}
+
+// WithRecreateOnImmutableChange makes ReconcileOwnedObjects delete and create objects of the types of objectTypes again,
+// if their update is rejected, because it changes immutable fields, e.g. a Job template, a Service clusterIP
+// or StatefulSet volumeClaimTemplates. Such objects are reported as OperationRecreated.
+// Updates of other types still fail. PlanOwnedObjects plans such changes as ActionRecreate.
+//
+// Only the object rejecting the update is deleted, and WithPropagationPolicy applies.
+// Objects still being deleted, e.g. because of finalizers, fail and are recreated in a later run,
+// use WithRecreateWaiter to wait for them to be gone.
+func WithRecreateOnImmutableChange(objectTypes ...runtime.Object) Option {
+	return func(o *options) {
+		o.recreateTypes = append(o.recreateTypes, objectTypes...)
+	}
+}
+
+// WithRecreateWaiter makes ReconcileOwnedObjects wait for recreated objects to be gone, before creating them again,
+// see WithRecreateOnImmutableChange. The waiter is usually a *util.ClientWatcher.
+func WithRecreateWaiter(waiter NotFoundWaiter, waitOptions ...util.ClientWatcherOption) Option {
+	return func(o *options) {
+		o.recreateWaiter = waiter
+		o.recreateWaitOptions = waitOptions
+	}
+}
+
+func buildOptions(opts []Option) *options {
+	o := &options{}
+	for _, f := range opts {
+		f(o)
+	}
+	return o
+}
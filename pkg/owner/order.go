@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package owner
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// applyOrder groups kinds by their dependencies.
+// Objects are created group by group and deleted in reverse order.
+// Kinds not listed here, e.g. custom resources, are applied last.
+var applyOrder = [][]string{
+	{"Namespace"},
+	{"CustomResourceDefinition"},
+	{
+		"PodSecurityPolicy", "ServiceAccount",
+		"ClusterRole", "ClusterRoleBinding", "Role", "RoleBinding",
+	},
+	{
+		"ResourceQuota", "LimitRange", "PriorityClass",
+		"ConfigMap", "Secret",
+		"StorageClass", "PersistentVolume", "PersistentVolumeClaim",
+	},
+	{
+		"Service", "Endpoints",
+		"Pod", "ReplicationController", "ReplicaSet", "Deployment", "StatefulSet", "DaemonSet", "Job", "CronJob",
+		"HorizontalPodAutoscaler", "PodDisruptionBudget", "Ingress", "NetworkPolicy",
+	},
+	{"APIService", "MutatingWebhookConfiguration", "ValidatingWebhookConfiguration"},
+}
+
+var applyRanks = func() map[string]int {
+	ranks := map[string]int{}
+	for rank, kinds := range applyOrder {
+		for _, kind := range kinds {
+			ranks[kind] = rank
+		}
+	}
+	return ranks
+}()
+
+// applyRank returns the position of the kind in the apply order.
+func applyRank(kind string) int {
+	if rank, ok := applyRanks[kind]; ok {
+		return rank
+	}
+	return len(applyOrder)
+}
+
+// sortByApplyOrder stable sorts the objects by apply order, or in reverse for deletion.
+func sortByApplyOrder(objs []runtime.Object, scheme *runtime.Scheme, reverse bool) error {
+	ranks := make(map[runtime.Object]int, len(objs))
+	for _, obj := range objs {
+		gvk, err := apiutil.GVKForObject(obj, scheme)
+		if err != nil {
+			return fmt.Errorf("cannot get GVK for %T: %w", obj, err)
+		}
+		ranks[obj] = applyRank(gvk.Kind)
+	}
+	sort.SliceStable(objs, func(i, j int) bool {
+		if reverse {
+			return ranks[objs[i]] > ranks[objs[j]]
+		}
+		return ranks[objs[i]] < ranks[objs[j]]
+	})
+	return nil
+}
+
+// applyGroups splits objects sorted by sortByApplyOrder into groups of the same apply rank.
+// Objects of a group do not depend on each other.
+func applyGroups(objs []runtime.Object, scheme *runtime.Scheme) ([][]runtime.Object, error) {
+	var (
+		groups   [][]runtime.Object
+		lastRank = -1
+	)
+	for _, obj := range objs {
+		gvk, err := apiutil.GVKForObject(obj, scheme)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get GVK for %T: %w", obj, err)
+		}
+		if rank := applyRank(gvk.Kind); len(groups) == 0 || rank != lastRank {
+			groups = append(groups, nil)
+			lastRank = rank
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], obj)
+	}
+	return groups, nil
+}
@@ -17,16 +17,25 @@ limitations under the License.
 package owner
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"k8c.io/utils/pkg/util"
 )
 
 const (
@@ -36,55 +45,183 @@ const (
 	OwnerNamespaceLabel = "owner.kubermatic.io/namespace"
 	// OwnerTypeLabel references the type of the owner of this object.
 	OwnerTypeLabel = "owner.kubermatic.io/type"
+	// OwnerReferenceAnnotation holds the full owner reference, when the owner labels are hashed.
+	OwnerReferenceAnnotation = "owner.kubermatic.io/reference"
+	// OwnerUIDAnnotation records the UID of the owner next to the owner labels.
+	// It tells an owner apart from a recreated owner with the same name.
+	OwnerUIDAnnotation = "owner.kubermatic.io/uid"
+	// KeepAnnotation protects an owned object from being pruned by ReconcileOwnedObjects, when set to "true".
+	KeepAnnotation = "owner.kubermatic.io/keep"
 )
 
+// ownerLabelKeys lists all owner labels in a stable order.
+var ownerLabelKeys = []string{OwnerNameLabel, OwnerNamespaceLabel, OwnerTypeLabel}
+
 type generalizedListOption interface {
 	client.ListOption
 	client.DeleteAllOfOption
 }
 
 // SetOwnerReference sets a the owner as owner of object.
-func SetOwnerReference(owner, object runtime.Object, scheme *runtime.Scheme) (changed bool, err error) {
+// It returns an OwnershipConflictError, if object is already owned by another owner.
+// The UID of the owner is recorded in the OwnerUIDAnnotation, if known.
+// Objects recording the UID of a previous owner with the same name fail with an OwnerUIDMismatchError,
+// unless WithAdoptPolicy(AdoptUnowned) is passed.
+//
+// See WithNativeOwnerReference to also set a metav1.OwnerReference.
+func SetOwnerReference(owner, object runtime.Object, scheme *runtime.Scheme, opts ...Option) (changed bool, err error) {
+	o := buildOptions(opts)
 	objectAccessor, err := meta.Accessor(object)
 	if err != nil {
 		panic(fmt.Errorf("cannot get accessor for %T :%w", object, err))
 	}
 
+	ownerRef, err := ownerReference(owner, scheme, o)
+	if err != nil {
+		return false, err
+	}
+	existingRef, owned, err := referenceFromObject(objectAccessor)
+	if err != nil {
+		return false, err
+	}
+	if owned && existingRef != ownerRef {
+		existingLabels, wantedLabels := plainLabels(existingRef), plainLabels(ownerRef)
+		for _, k := range ownerLabelKeys {
+			if existingLabels[k] != wantedLabels[k] {
+				// label is overriden.
+				return false, &OwnershipConflictError{
+					Object:    objectReference(object, objectAccessor, scheme),
+					Existing:  existingRef,
+					Requested: ownerRef,
+				}
+			}
+		}
+	}
+
+	var ownerUID types.UID
+	if ownerAccessor, err := meta.Accessor(owner); err == nil {
+		ownerUID = ownerAccessor.GetUID()
+	}
+	if recorded, ok := GetOwnerUID(objectAccessor); ok && ownerUID != "" && recorded != ownerUID && o.adoptPolicy != AdoptUnowned {
+		// the object belongs to a previous owner with the same name.
+		return false, &OwnerUIDMismatchError{
+			Object:   objectReference(object, objectAccessor, scheme),
+			Owner:    ownerRef,
+			Recorded: recorded,
+			Current:  ownerUID,
+		}
+	}
+
 	labels := objectAccessor.GetLabels()
 	if labels == nil {
 		labels = map[string]string{}
 	}
-
-	ownerLabels := labelsForOwner(owner, scheme)
-	for k, v := range ownerLabels {
-		if labels[k] == "" {
-			// label was not set before.
+	wantedLabels := labelsForReference(ownerRef, o)
+	for _, k := range ownerLabelKeys {
+		v, wanted := wantedLabels[k]
+		current, present := labels[k]
+		if !wanted {
+			// e.g. the namespace label of cluster-scoped owners.
+			if present {
+				changed = true
+				delete(labels, k)
+			}
+			continue
+		}
+		if !present || current != v {
+			// label was not set before or is migrated between plain and hashed values.
 			changed = true
-		} else if labels[k] != v {
-			// label is overriden.
-			return changed, fmt.Errorf("tried to override owner reference: %s=%s to =%s", k, labels[k], v)
 		}
 		labels[k] = v
 	}
 	objectAccessor.SetLabels(labels)
+
+	annotations := objectAccessor.GetAnnotations()
+	if o.hashedLabels {
+		b, err := json.Marshal(ownerRef)
+		if err != nil {
+			return false, fmt.Errorf("marshalling owner reference: %w", err)
+		}
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		if annotations[OwnerReferenceAnnotation] != string(b) {
+			changed = true
+		}
+		annotations[OwnerReferenceAnnotation] = string(b)
+		objectAccessor.SetAnnotations(annotations)
+	} else if _, ok := annotations[OwnerReferenceAnnotation]; ok {
+		changed = true
+		delete(annotations, OwnerReferenceAnnotation)
+		objectAccessor.SetAnnotations(annotations)
+	}
+
+	if ownerUID != "" {
+		// owners without UID, e.g. not yet created ones, keep the recorded UID.
+		uid := string(ownerUID)
+		annotations := objectAccessor.GetAnnotations()
+		if annotations[OwnerUIDAnnotation] != uid {
+			changed = true
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[OwnerUIDAnnotation] = uid
+			objectAccessor.SetAnnotations(annotations)
+		}
+	}
+
+	if o.clusterName != "" {
+		clusterChanged, err := util.SetClusterLabel(objectAccessor, o.clusterName)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || clusterChanged
+	}
+
+	if o.nativeOwnerReference && o.clusterName == "" {
+		nativeChanged, err := util.SetNativeOwnerReference(owner, objectAccessor, ownerRef, scheme, o.controller, o.blockOwnerDeletion)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || nativeChanged
+	}
 	return
 }
 
 // RemoveOwnerReference removes an owner from the given object.
-func RemoveOwnerReference(owner, object runtime.Object) (changed bool) {
+// With WithNativeOwnerReference, the metav1.OwnerReference to the labeled owner is removed as well.
+func RemoveOwnerReference(owner, object runtime.Object, opts ...Option) (changed bool) {
+	o := buildOptions(opts)
 	objectAccessor, err := meta.Accessor(object)
 	if err != nil {
 		panic(fmt.Errorf("cannot get accessor for %T :%w", object, err))
 	}
 
+	if o.nativeOwnerReference && o.clusterName == "" {
+		if ref, owned, err := referenceFromObject(objectAccessor); err == nil && owned {
+			changed = util.RemoveNativeOwnerReference(objectAccessor, ref)
+		}
+	}
+
+	if annotations := objectAccessor.GetAnnotations(); annotations != nil {
+		for _, k := range []string{OwnerReferenceAnnotation, OwnerUIDAnnotation} {
+			if _, ok := annotations[k]; ok {
+				changed = true
+				delete(annotations, k)
+				objectAccessor.SetAnnotations(annotations)
+			}
+		}
+	}
+
 	labels := objectAccessor.GetLabels()
 	if labels == nil {
 		return
 	}
 
-	if labels[OwnerNameLabel] != "" || labels[OwnerNamespaceLabel] != "" || labels[OwnerTypeLabel] != "" {
+	if labels[OwnerNameLabel] != "" || labels[OwnerNamespaceLabel] != "" || labels[OwnerTypeLabel] != "" || labels[util.ClusterLabel] != "" {
 		changed = true
 	}
+	delete(labels, util.ClusterLabel)
 	delete(labels, OwnerNameLabel)
 	delete(labels, OwnerNamespaceLabel)
 	delete(labels, OwnerTypeLabel)
@@ -92,7 +229,7 @@ func RemoveOwnerReference(owner, object runtime.Object) (changed bool) {
 	return
 }
 
-func requestHandlerForOwner(ownerType runtime.Object, scheme *runtime.Scheme) handler.ToRequestsFunc {
+func requestHandlerForOwner(ownerType runtime.Object, scheme *runtime.Scheme, o *options) handler.ToRequestsFunc {
 	gvk, err := apiutil.GVKForObject(ownerType, scheme)
 	if err != nil {
 		// if this panic occurs many, many other stuff has gone wrong as well
@@ -106,85 +243,249 @@ func requestHandlerForOwner(ownerType runtime.Object, scheme *runtime.Scheme) ha
 		panic(fmt.Sprintf("cannot deduce GVK for owner (type %T)", ownerType))
 	}
 
-	gk := gvk.GroupKind().String()
+	gk := gvk.GroupKind()
 
-	return func(obj handler.MapObject) (requests []reconcile.Request) {
-		labels := obj.Meta.GetLabels()
-		if labels == nil {
-			return
+	// without a RESTMapper, the scope is deduced from the namespace label.
+	var clusterScoped, namespaced bool
+	if o.restMapper != nil {
+		mapping, err := o.restMapper.RESTMapping(gk, gvk.Version)
+		if err != nil {
+			utilruntime.HandleError(fmt.Errorf("getting REST mapping for owner type %s: %w", gk, err))
+		} else {
+			clusterScoped = mapping.Scope.Name() == meta.RESTScopeNameRoot
+			namespaced = !clusterScoped
 		}
+	}
 
-		ownerName, ok := labels[OwnerNameLabel]
-		if !ok {
+	return func(obj handler.MapObject) (requests []reconcile.Request) {
+		ref, owned, err := referenceFromObject(obj.Meta)
+		if err != nil {
+			utilruntime.HandleError(
+				fmt.Errorf("parsing owner reference name=%s namespace=%s: %w",
+					obj.Meta.GetName(), obj.Meta.GetNamespace(), err))
 			return
 		}
-		ownerNamespace, ok := labels[OwnerNamespaceLabel]
-		if !ok {
+		if !owned {
 			return
 		}
-		ownerType, ok := labels[OwnerTypeLabel]
-		if !ok {
+
+		if ref.Kind != gk.Kind || ref.Group != gk.Group {
 			return
 		}
-
-		if ownerType != gk {
+		if clusterScoped {
+			ref.Namespace = ""
+		}
+		if namespaced && ref.Namespace == "" {
 			return
 		}
 
-		requests = append(requests, reconcile.Request{
+		request := reconcile.Request{
 			NamespacedName: types.NamespacedName{
-				Name:      ownerName,
-				Namespace: ownerNamespace,
+				Name:      ref.Name,
+				Namespace: ref.Namespace,
 			},
-		})
+		}
+		if o.uidCheckReader != nil {
+			checkOwnerUIDOf(o.uidCheckReader, scheme, ownerType, request.NamespacedName, obj, ref)
+		}
+		return append(requests, request)
+	}
+}
+
+// checkOwnerUIDOf reports, if the object records another UID than the current owner.
+func checkOwnerUIDOf(reader client.Reader, scheme *runtime.Scheme, ownerType runtime.Object, key types.NamespacedName, obj handler.MapObject, ownerRef util.ObjectReference) {
+	recorded, ok := GetOwnerUID(obj.Meta)
+	if !ok {
+		return
+	}
+	ownerObj, err := util.NewObject(ownerType, scheme)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	if err := reader.Get(context.Background(), key, ownerObj); err != nil {
+		if !errors.IsNotFound(err) {
+			utilruntime.HandleError(fmt.Errorf("getting owner %s: %w", ownerRef, err))
+		}
 		return
 	}
+	ownerAccessor, err := meta.Accessor(ownerObj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	if current := ownerAccessor.GetUID(); current != "" && current != recorded {
+		utilruntime.HandleError(&OwnerUIDMismatchError{
+			Object:   objectReference(obj.Object, obj.Meta, scheme),
+			Owner:    ownerRef,
+			Recorded: recorded,
+			Current:  current,
+		})
+	}
 }
 
 // EnqueueRequestForOwner enqueues a request for the owner of an object
-func EnqueueRequestForOwner(ownerType runtime.Object, scheme *runtime.Scheme) handler.EventHandler {
+//
+// Requests for cluster-scoped owners have an empty namespace.
+// With WithOwnerUIDCheck, objects recording another UID than the current owner are reported as OwnerUIDMismatchError.
+func EnqueueRequestForOwner(ownerType runtime.Object, scheme *runtime.Scheme, opts ...Option) handler.EventHandler {
 	return &handler.EnqueueRequestsFromMapFunc{
-		ToRequests: requestHandlerForOwner(ownerType, scheme),
+		ToRequests: requestHandlerForOwner(ownerType, scheme, buildOptions(opts)),
 	}
 }
 
 // OwnedBy returns a list filter to fetch owned objects.
-func OwnedBy(owner runtime.Object, scheme *runtime.Scheme) generalizedListOption {
-	return client.MatchingLabels(labelsForOwner(owner, scheme))
+//
+// Objects owned with hashed labels are only matched, if WithHashedLabels is passed as well.
+// If the scope of the owner cannot be resolved through the RESTMapper, the owner namespace is used as is.
+func OwnedBy(owner runtime.Object, scheme *runtime.Scheme, opts ...Option) generalizedListOption {
+	o := buildOptions(opts)
+	ref, err := ownerReference(owner, scheme, o)
+	if err != nil {
+		utilruntime.HandleError(err)
+		ref = util.ToObjectReference(owner, scheme)
+	}
+	return ownedBy(ref, o)
+}
+
+func ownedBy(ownerRef util.ObjectReference, o *options) generalizedListOption {
+	labels := labelsForReference(ownerRef, o)
+	if o.clusterName != "" {
+		labels[util.ClusterLabel] = o.clusterName
+	}
+	return client.MatchingLabels(labels)
 }
 
 // IsOwned checks if any owners claim ownership of this object.
 func IsOwned(object metav1.Object) (owned bool) {
-	l := object.GetLabels()
-	if l == nil {
-		return false
+	_, owned, err := referenceFromObject(object)
+	return err == nil && owned
+}
+
+// ownerReference returns the reference to owner, taking the scope of the owner into account.
+func ownerReference(owner runtime.Object, scheme *runtime.Scheme, o *options) (util.ObjectReference, error) {
+	ref := util.ToObjectReference(owner, scheme)
+	if o.restMapper == nil {
+		return ref, nil
 	}
 
-	return l[OwnerNameLabel] != "" && l[OwnerNamespaceLabel] != "" && l[OwnerTypeLabel] != ""
+	gvk, err := apiutil.GVKForObject(owner, scheme)
+	if err != nil {
+		return ref, fmt.Errorf("cannot get GVK for %T: %w", owner, err)
+	}
+	mapping, err := o.restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return ref, fmt.Errorf("getting REST mapping for owner %s: %w", ref, err)
+	}
+	switch {
+	case mapping.Scope.Name() == meta.RESTScopeNameRoot:
+		ref.Namespace = ""
+	case ref.Namespace == "":
+		return ref, fmt.Errorf("namespaced owner %s has no namespace", ref)
+	}
+	return ref, nil
 }
 
-func labelsForOwner(obj runtime.Object, scheme *runtime.Scheme) map[string]string {
-	gvk, err := apiutil.GVKForObject(obj, scheme)
-	if err != nil {
-		// if this panic occurs many, many other stuff has gone wrong as well
-		// by owner type's safety ensures this is somewhat well formed k8s object
-		// When using client-go API, it needs to be able to deduce GVK in the same manner
-		// thus get/create/update/patch/delete shall error out long before this is called
-		// This massively simplifies the function interface and allows OwnedBy to be a
-		// one-liner instead of 3 line check which never errors
-		// this is error is completely under our control, users of kubermatic cannot
-		// change cluster state to cause it.
-		panic(fmt.Sprintf("cannot deduce GVK for owner (type %T)", obj))
+// objectReference is like util.ToObjectReference, but leaves the type empty for types unknown to the scheme.
+func objectReference(object runtime.Object, accessor metav1.Object, scheme *runtime.Scheme) util.ObjectReference {
+	ref := util.ObjectReference{
+		Name:      accessor.GetName(),
+		Namespace: accessor.GetNamespace(),
+	}
+	if gvk, err := apiutil.GVKForObject(object, scheme); err == nil {
+		ref.Group, ref.Kind = gvk.Group, gvk.Kind
 	}
+	return ref
+}
 
-	metaAccessor, err := meta.Accessor(obj)
+// GetOwnerUID returns the owner UID recorded on the given object.
+func GetOwnerUID(object metav1.Object) (uid types.UID, ok bool) {
+	value, ok := object.GetAnnotations()[OwnerUIDAnnotation]
+	return types.UID(value), ok && value != ""
+}
+
+// CheckOwnerUID returns an OwnerUIDMismatchError, if object records another UID than the one of owner,
+// meaning object belongs to a previous owner with the same name.
+// Objects without recorded UID and owners without UID always pass.
+func CheckOwnerUID(owner, object runtime.Object, scheme *runtime.Scheme) error {
+	objectAccessor, err := meta.Accessor(object)
+	if err != nil {
+		return err
+	}
+	ownerAccessor, err := meta.Accessor(owner)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	recorded, ok := GetOwnerUID(objectAccessor)
+	current := ownerAccessor.GetUID()
+	if !ok || current == "" || recorded == current {
+		return nil
 	}
+	return &OwnerUIDMismatchError{
+		Object:   util.ToObjectReference(object, scheme),
+		Owner:    util.ToObjectReference(owner, scheme),
+		Recorded: recorded,
+		Current:  current,
+	}
+}
 
+// GetOwnerReference returns the owner recorded on the given object.
+// Both plain and hashed owner labels are understood.
+func GetOwnerReference(object metav1.Object) (ref util.ObjectReference, owned bool, err error) {
+	return referenceFromObject(object)
+}
+
+// referenceFromObject reads the owner reference from the OwnerReferenceAnnotation,
+// falling back to the plain owner labels.
+func referenceFromObject(object metav1.Object) (ref util.ObjectReference, owned bool, err error) {
+	if data, ok := object.GetAnnotations()[OwnerReferenceAnnotation]; ok {
+		if err := json.Unmarshal([]byte(data), &ref); err != nil {
+			return ref, false, fmt.Errorf("unmarshalling %s annotation: %w", OwnerReferenceAnnotation, err)
+		}
+		return ref, true, nil
+	}
+
+	// the namespace label is absent or, if written without RESTMapper, empty for cluster-scoped owners.
+	l := object.GetLabels()
+	if l[OwnerNameLabel] == "" || l[OwnerTypeLabel] == "" {
+		return ref, false, nil
+	}
+	gk := schema.ParseGroupKind(l[OwnerTypeLabel])
+	return util.ObjectReference{
+		Name:      l[OwnerNameLabel],
+		Namespace: l[OwnerNamespaceLabel],
+		Group:     gk.Group,
+		Kind:      gk.Kind,
+	}, true, nil
+}
+
+// labelsForReference returns the owner labels for ref.
+// The namespace label is only omitted for owners known to be cluster-scoped through the RESTMapper,
+// otherwise an owner missing its namespace would select objects of equally named owners in all namespaces.
+func labelsForReference(ref util.ObjectReference, o *options) map[string]string {
+	labels := plainLabels(ref)
+	if ref.Namespace == "" && o.restMapper != nil {
+		// ownerReference rejects namespaced owners without namespace.
+		delete(labels, OwnerNamespaceLabel)
+	}
+	if o.hashedLabels {
+		for k, v := range labels {
+			labels[k] = hashLabelValue(v)
+		}
+	}
+	return labels
+}
+
+func plainLabels(ref util.ObjectReference) map[string]string {
 	return map[string]string{
-		OwnerNameLabel:      metaAccessor.GetName(),
-		OwnerNamespaceLabel: metaAccessor.GetNamespace(),
-		OwnerTypeLabel:      gvk.GroupKind().String(),
+		OwnerNameLabel:      ref.Name,
+		OwnerNamespaceLabel: ref.Namespace,
+		OwnerTypeLabel:      schema.GroupKind{Group: ref.Group, Kind: ref.Kind}.String(),
 	}
 }
+
+// hashLabelValue returns a stable digest of value, which is always a valid label value.
+func hashLabelValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:20])
+}
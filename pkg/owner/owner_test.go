@@ -17,27 +17,44 @@ limitations under the License.
 package owner
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"k8c.io/utils/pkg/util"
 )
 
-var testScheme = runtime.NewScheme()
+var (
+	testScheme     = runtime.NewScheme()
+	testRESTMapper = meta.NewDefaultRESTMapper(nil)
+)
 
 func init() {
 	// setup scheme for all tests
 	utilruntime.Must(corev1.AddToScheme(testScheme))
 	utilruntime.Must(apiextensionsv1.AddToScheme(testScheme))
+
+	testRESTMapper.Add(corev1.SchemeGroupVersion.WithKind("Namespace"), meta.RESTScopeRoot)
+	testRESTMapper.Add(corev1.SchemeGroupVersion.WithKind("Secret"), meta.RESTScopeNamespace)
+	testRESTMapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
 }
 
 func TestSetOwnerReference(t *testing.T) {
@@ -88,6 +105,162 @@ func TestSetOwnerReference(t *testing.T) {
 		_, err := SetOwnerReference(owner, obj, testScheme)
 		require.Error(t, err)
 		assert.Equal(t, "tried to override owner reference: owner.kubermatic.io/name=sepp to =hans", err.Error())
+		var conflict *OwnershipConflictError
+		if assert.True(t, errors.As(err, &conflict)) {
+			assert.Equal(t, "sepp", conflict.Existing.Name)
+			assert.Equal(t, "hans", conflict.Requested.Name)
+		}
+	})
+
+	t.Run("hashed labels", func(t *testing.T) {
+		owner := &unstructured.Unstructured{}
+		owner.SetName(strings.Repeat("hans", 20))
+		owner.SetNamespace("hans-playground")
+		owner.SetGroupVersionKind(schema.GroupVersionKind{
+			Group:   "a-very-long-api-group-name-for-testing.test.kubermatic.io",
+			Kind:    "Test",
+			Version: "v1alpha1",
+		})
+
+		obj := &unstructured.Unstructured{}
+
+		changed, err := SetOwnerReference(owner, obj, testScheme, WithHashedLabels())
+		require.NoError(t, err)
+		assert.True(t, changed)
+		for _, k := range ownerLabelKeys {
+			assert.Empty(t, validation.IsValidLabelValue(obj.GetLabels()[k]), k)
+		}
+		assert.Equal(t,
+			`{"name":"`+owner.GetName()+`","namespace":"hans-playground","group":"a-very-long-api-group-name-for-testing.test.kubermatic.io","kind":"Test"}`,
+			obj.GetAnnotations()[OwnerReferenceAnnotation])
+		assert.True(t, IsOwned(obj))
+
+		changed, err = SetOwnerReference(owner, obj, testScheme, WithHashedLabels())
+		require.NoError(t, err)
+		assert.False(t, changed)
+
+		assert.True(t, RemoveOwnerReference(owner, obj))
+		assert.False(t, IsOwned(obj))
+		assert.Empty(t, obj.GetLabels())
+		assert.Empty(t, obj.GetAnnotations())
+	})
+
+	t.Run("migrate plain to hashed", func(t *testing.T) {
+		owner := &unstructured.Unstructured{}
+		owner.SetName("hans")
+		owner.SetNamespace("hans-playground")
+		owner.SetGroupVersionKind(schema.GroupVersionKind{
+			Group:   "test.kubermatic.io",
+			Kind:    "Test",
+			Version: "v1alpha1",
+		})
+
+		obj := &unstructured.Unstructured{}
+		changed, err := SetOwnerReference(owner, obj, testScheme)
+		require.NoError(t, err)
+		assert.True(t, changed)
+
+		changed, err = SetOwnerReference(owner, obj, testScheme, WithHashedLabels())
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, hashLabelValue("hans"), obj.GetLabels()[OwnerNameLabel])
+
+		changed, err = SetOwnerReference(owner, obj, testScheme)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, "hans", obj.GetLabels()[OwnerNameLabel])
+		assert.NotContains(t, obj.GetAnnotations(), OwnerReferenceAnnotation)
+	})
+
+	t.Run("cluster-scoped owner", func(t *testing.T) {
+		owner := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "hans"}}
+
+		obj := &unstructured.Unstructured{}
+		changed, err := SetOwnerReference(owner, obj, testScheme, WithRESTMapper(testRESTMapper))
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, map[string]string{
+			OwnerNameLabel: "hans",
+			OwnerTypeLabel: "Namespace",
+		}, obj.GetLabels())
+		assert.True(t, IsOwned(obj))
+
+		changed, err = SetOwnerReference(owner, obj, testScheme, WithRESTMapper(testRESTMapper))
+		require.NoError(t, err)
+		assert.False(t, changed)
+	})
+
+	t.Run("owner without namespace and RESTMapper", func(t *testing.T) {
+		// the scope is unknown, e.g. a namespaced owner missing its namespace.
+		owner := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "hans"}}
+
+		obj := &unstructured.Unstructured{}
+		_, err := SetOwnerReference(owner, obj, testScheme)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			OwnerNameLabel:      "hans",
+			OwnerNamespaceLabel: "",
+			OwnerTypeLabel:      "Secret",
+		}, obj.GetLabels())
+
+		namespacedObj := &unstructured.Unstructured{}
+		_, err = SetOwnerReference(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "hans", Namespace: "default"}}, namespacedObj, testScheme)
+		require.NoError(t, err)
+		listOpts := &client.ListOptions{}
+		OwnedBy(owner, testScheme).ApplyToList(listOpts)
+		assert.True(t, listOpts.LabelSelector.Matches(labels.Set(obj.GetLabels())))
+		assert.False(t, listOpts.LabelSelector.Matches(labels.Set(namespacedObj.GetLabels())), "owner in another namespace")
+	})
+
+	t.Run("cluster-scoped owner with empty namespace label", func(t *testing.T) {
+		owner := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "hans"}}
+
+		obj := &unstructured.Unstructured{}
+		obj.SetLabels(map[string]string{
+			OwnerNameLabel:      "hans",
+			OwnerNamespaceLabel: "",
+			OwnerTypeLabel:      "Namespace",
+		})
+		changed, err := SetOwnerReference(owner, obj, testScheme, WithRESTMapper(testRESTMapper))
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.NotContains(t, obj.GetLabels(), OwnerNamespaceLabel)
+	})
+
+	t.Run("cluster-scoped owner scope from RESTMapper", func(t *testing.T) {
+		owner := &unstructured.Unstructured{}
+		owner.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Namespace"))
+		owner.SetName("hans")
+		owner.SetNamespace("bogus")
+
+		obj := &unstructured.Unstructured{}
+		_, err := SetOwnerReference(owner, obj, testScheme, WithRESTMapper(testRESTMapper))
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			OwnerNameLabel: "hans",
+			OwnerTypeLabel: "Namespace",
+		}, obj.GetLabels())
+	})
+
+	t.Run("namespaced owner without namespace", func(t *testing.T) {
+		owner := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "hans"}}
+
+		obj := &unstructured.Unstructured{}
+		_, err := SetOwnerReference(owner, obj, testScheme, WithRESTMapper(testRESTMapper))
+		require.Error(t, err)
+		assert.Equal(t, "namespaced owner Secret./:hans has no namespace", err.Error())
+	})
+
+	t.Run("hashed override protection", func(t *testing.T) {
+		ownerA := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default"}}
+		ownerB := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "default"}}
+
+		obj := &unstructured.Unstructured{}
+		_, err := SetOwnerReference(ownerA, obj, testScheme, WithHashedLabels())
+		require.NoError(t, err)
+		_, err = SetOwnerReference(ownerB, obj, testScheme, WithHashedLabels())
+		require.Error(t, err)
+		assert.Equal(t, "tried to override owner reference: owner.kubermatic.io/name=a to =b", err.Error())
 	})
 }
 
@@ -117,6 +290,15 @@ func Test_requestHandlerForOwner(t *testing.T) {
 		OwnerTypeLabel:      "Test.example.io",
 	})
 
+	hashedOwner := &unstructured.Unstructured{}
+	hashedOwner.SetGroupVersionKind(owner.GroupVersionKind())
+	hashedOwner.SetName(strings.Repeat("test", 20))
+	hashedOwner.SetNamespace("hans3000")
+	matchingHashedObj := &unstructured.Unstructured{}
+	matchingHashedObj.SetName("test")
+	_, err := SetOwnerReference(hashedOwner, matchingHashedObj, testScheme, WithHashedLabels())
+	require.NoError(t, err)
+
 	nonMatchingNoLabelObj := &unstructured.Unstructured{}
 	nonMatchingNoLabelObj.SetName("test")
 	nonMatchingNoLabelObj.SetName("test-ns")
@@ -138,6 +320,18 @@ func Test_requestHandlerForOwner(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "should match hashed",
+			obj:  matchingHashedObj,
+			requests: []reconcile.Request{
+				{
+					NamespacedName: types.NamespacedName{
+						Name:      hashedOwner.GetName(),
+						Namespace: "hans3000",
+					},
+				},
+			},
+		},
 		{
 			name: "filtered by type",
 			obj:  nonMatchingTypeObj,
@@ -148,7 +342,7 @@ func Test_requestHandlerForOwner(t *testing.T) {
 		},
 	}
 
-	handlerFn := requestHandlerForOwner(owner, testScheme)
+	handlerFn := requestHandlerForOwner(owner, testScheme, &options{})
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			requests := handlerFn(handler.MapObject{
@@ -159,3 +353,239 @@ func Test_requestHandlerForOwner(t *testing.T) {
 		})
 	}
 }
+
+func Test_requestHandlerForOwnerScopes(t *testing.T) {
+	clusterScopedObj := &unstructured.Unstructured{}
+	clusterScopedObj.SetName("test")
+	clusterScopedObj.SetLabels(map[string]string{
+		OwnerNameLabel: "hans",
+		OwnerTypeLabel: "Namespace",
+	})
+
+	legacyClusterScopedObj := &unstructured.Unstructured{}
+	legacyClusterScopedObj.SetName("test")
+	legacyClusterScopedObj.SetLabels(map[string]string{
+		OwnerNameLabel:      "hans",
+		OwnerNamespaceLabel: "hans-playground",
+		OwnerTypeLabel:      "Namespace",
+	})
+
+	namespacedObj := &unstructured.Unstructured{}
+	namespacedObj.SetName("test")
+	namespacedObj.SetLabels(map[string]string{
+		OwnerNameLabel:      "hans",
+		OwnerNamespaceLabel: "hans-playground",
+		OwnerTypeLabel:      "Secret",
+	})
+
+	namespacedWithoutNamespaceObj := &unstructured.Unstructured{}
+	namespacedWithoutNamespaceObj.SetName("test")
+	namespacedWithoutNamespaceObj.SetLabels(map[string]string{
+		OwnerNameLabel: "hans",
+		OwnerTypeLabel: "Secret",
+	})
+
+	tests := []struct {
+		name      string
+		ownerType runtime.Object
+		obj       *unstructured.Unstructured
+		requests  []reconcile.Request
+	}{
+		{
+			name:      "cluster-scoped",
+			ownerType: &corev1.Namespace{},
+			obj:       clusterScopedObj,
+			requests: []reconcile.Request{
+				{NamespacedName: types.NamespacedName{Name: "hans"}},
+			},
+		},
+		{
+			name:      "cluster-scoped ignores namespace label",
+			ownerType: &corev1.Namespace{},
+			obj:       legacyClusterScopedObj,
+			requests: []reconcile.Request{
+				{NamespacedName: types.NamespacedName{Name: "hans"}},
+			},
+		},
+		{
+			name:      "namespaced",
+			ownerType: &corev1.Secret{},
+			obj:       namespacedObj,
+			requests: []reconcile.Request{
+				{NamespacedName: types.NamespacedName{Name: "hans", Namespace: "hans-playground"}},
+			},
+		},
+		{
+			name:      "namespaced without namespace label",
+			ownerType: &corev1.Secret{},
+			obj:       namespacedWithoutNamespaceObj,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handlerFn := requestHandlerForOwner(test.ownerType, testScheme, buildOptions([]Option{WithRESTMapper(testRESTMapper)}))
+			requests := handlerFn(handler.MapObject{
+				Meta:   test.obj,
+				Object: test.obj,
+			})
+			assert.Equal(t, test.requests, requests)
+		})
+	}
+}
+
+func TestOwnerUID(t *testing.T) {
+	owner := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "default", UID: "current-uid"}}
+	obj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"}}
+
+	changed, err := SetOwnerReference(owner, obj, testScheme)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	uid, ok := GetOwnerUID(obj)
+	assert.True(t, ok)
+	assert.Equal(t, types.UID("current-uid"), uid)
+	assert.NoError(t, CheckOwnerUID(owner, obj, testScheme))
+
+	recreated := owner.DeepCopy()
+	recreated.UID = "recreated-uid"
+	err = CheckOwnerUID(recreated, obj, testScheme)
+	var mismatch *OwnerUIDMismatchError
+	if assert.True(t, errors.As(err, &mismatch)) {
+		assert.Equal(t, types.UID("current-uid"), mismatch.Recorded)
+		assert.Equal(t, types.UID("recreated-uid"), mismatch.Current)
+		assert.Equal(t, "cm", mismatch.Object.Name)
+	}
+
+	// the UID of a previous owner is only replaced, if adopting.
+	_, err = SetOwnerReference(recreated, obj, testScheme)
+	assert.True(t, IsOwnerUIDMismatch(err))
+	uid, _ = GetOwnerUID(obj)
+	assert.Equal(t, types.UID("current-uid"), uid)
+	// the object is left unchanged, even if its labels would be migrated.
+	unchanged := obj.DeepCopy()
+	_, err = SetOwnerReference(recreated, unchanged, testScheme, WithHashedLabels())
+	assert.True(t, IsOwnerUIDMismatch(err))
+	assert.Equal(t, obj, unchanged)
+	adopted := obj.DeepCopy()
+	changed, err = SetOwnerReference(recreated, adopted, testScheme, WithAdoptPolicy(AdoptUnowned))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	uid, _ = GetOwnerUID(adopted)
+	assert.Equal(t, types.UID("recreated-uid"), uid)
+
+	// owners without UID keep the recorded one.
+	withoutUID := owner.DeepCopy()
+	withoutUID.UID = ""
+	changed, err = SetOwnerReference(withoutUID, obj, testScheme)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.NoError(t, CheckOwnerUID(withoutUID, obj, testScheme))
+
+	assert.True(t, RemoveOwnerReference(nil, obj))
+	_, ok = GetOwnerUID(obj)
+	assert.False(t, ok)
+}
+
+func TestNativeOwnerReference(t *testing.T) {
+	owner := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "default", UID: "owner-uid"}}
+	opt := WithNativeOwnerReference(true, true)
+
+	obj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"}}
+	changed, err := SetOwnerReference(owner, obj, testScheme, opt)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.True(t, IsOwned(obj))
+	controller, block := true, true
+	assert.Equal(t, []metav1.OwnerReference{{
+		APIVersion: "v1", Kind: "Secret", Name: "owner", UID: "owner-uid",
+		Controller: &controller, BlockOwnerDeletion: &block,
+	}}, obj.OwnerReferences)
+
+	changed, err = SetOwnerReference(owner, obj, testScheme, opt)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// without the option, native references are left alone.
+	assert.True(t, RemoveOwnerReference(nil, obj.DeepCopy()))
+	assert.Len(t, obj.OwnerReferences, 1)
+	assert.True(t, RemoveOwnerReference(nil, obj, opt))
+	assert.Empty(t, obj.OwnerReferences)
+	assert.False(t, IsOwned(obj))
+
+	// cross-namespace owners are recorded in labels only.
+	crossNamespace := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "other"}}
+	changed, err = SetOwnerReference(owner, crossNamespace, testScheme, opt)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.True(t, IsOwned(crossNamespace))
+	assert.Empty(t, crossNamespace.OwnerReferences)
+
+	// cluster-scoped owners can own namespaced objects.
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", UID: "ns-uid"}}
+	obj = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"}}
+	_, err = SetOwnerReference(ns, obj, testScheme, opt, WithRESTMapper(testRESTMapper))
+	require.NoError(t, err)
+	assert.Len(t, obj.OwnerReferences, 1)
+
+	// owners without UID cannot be referenced natively.
+	obj = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"}}
+	_, err = SetOwnerReference(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "default"}}, obj, testScheme, opt)
+	require.NoError(t, err)
+	assert.True(t, IsOwned(obj))
+	assert.Empty(t, obj.OwnerReferences)
+}
+
+func TestClusterName(t *testing.T) {
+	owner := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "default"}}
+	opt := WithClusterName("a")
+
+	obj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"}}
+	changed, err := SetOwnerReference(owner, obj, testScheme, opt)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "a", obj.Labels[util.ClusterLabel])
+
+	listOpts := &client.ListOptions{}
+	OwnedBy(owner, testScheme, opt).ApplyToList(listOpts)
+	assert.True(t, listOpts.LabelSelector.Matches(labels.Set(obj.Labels)))
+	listOpts = &client.ListOptions{}
+	OwnedBy(owner, testScheme, WithClusterName("b")).ApplyToList(listOpts)
+	assert.False(t, listOpts.LabelSelector.Matches(labels.Set(obj.Labels)), "object of another cluster")
+
+	_, err = SetOwnerReference(owner, obj, testScheme, WithClusterName("b"))
+	assert.Error(t, err, "object of another cluster")
+
+	assert.True(t, RemoveOwnerReference(owner, obj))
+	assert.NotContains(t, obj.Labels, util.ClusterLabel)
+
+	// native references to owners in another cluster would be garbage collected.
+	ownerWithUID := owner.DeepCopy()
+	ownerWithUID.UID = "owner-uid"
+	_, err = SetOwnerReference(ownerWithUID, obj, testScheme, opt, WithNativeOwnerReference(true, false))
+	require.NoError(t, err)
+	assert.Empty(t, obj.OwnerReferences)
+}
+
+func Test_requestHandlerForOwnerUIDCheck(t *testing.T) {
+	owner := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "default", UID: "recreated-uid"}}
+	obj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"}}
+	_, err := SetOwnerReference(owner, obj, testScheme)
+	require.NoError(t, err)
+	obj.Annotations[OwnerUIDAnnotation] = "previous-uid"
+
+	var reported []error
+	errorHandlers := utilruntime.ErrorHandlers
+	utilruntime.ErrorHandlers = []func(error){func(err error) {
+		reported = append(reported, err)
+	}}
+	defer func() { utilruntime.ErrorHandlers = errorHandlers }()
+
+	reader := fakeclient.NewFakeClientWithScheme(testScheme, owner)
+	handlerFn := requestHandlerForOwner(&corev1.Secret{}, testScheme, buildOptions([]Option{WithOwnerUIDCheck(reader)}))
+	requests := handlerFn(handler.MapObject{Meta: obj, Object: obj})
+
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "owner", Namespace: "default"}}}, requests)
+	if assert.Len(t, reported, 1) {
+		assert.True(t, IsOwnerUIDMismatch(reported[0]))
+	}
+}
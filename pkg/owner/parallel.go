@@ -0,0 +1,97 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package owner
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// outcome is the outcome of processing a single object.
+type outcome struct {
+	op     Operation
+	reason string
+	diff   Diff
+	err    error
+	// conflict is set for objects skipped due to an ownership conflict.
+	conflict *OwnershipConflictError
+	// writeSkipped is set for objects, which are up to date with their desired state hash.
+	writeSkipped bool
+}
+
+// forEachInApplyGroups calls process for all objects, which must be sorted by sortByApplyOrder.
+//
+// Objects of the same apply group are processed by up to workers goroutines,
+// the next group is only started once the previous one is done.
+// Outcomes are reported in the order of objs, so the reports do not depend on the number of workers.
+// Once the context is cancelled, no further objects are processed and the context error is returned.
+func forEachInApplyGroups(ctx context.Context, objs []runtime.Object, scheme *runtime.Scheme, workers int, process func(runtime.Object) outcome, report func(runtime.Object, outcome) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	groups, err := applyGroups(objs, scheme)
+	if err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		outcomes := make([]outcome, len(group))
+		sem := make(chan struct{}, workers)
+		wg := sync.WaitGroup{}
+		started := 0
+		for i, obj := range group {
+			if !acquire(ctx, sem) {
+				break
+			}
+			started++
+			wg.Add(1)
+			go func(i int, obj runtime.Object) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				outcomes[i] = process(obj)
+			}(i, obj)
+		}
+		wg.Wait()
+
+		for i := 0; i < started; i++ {
+			if err := report(group[i], outcomes[i]); err != nil {
+				return err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// acquire takes a slot of the semaphore, unless the context is cancelled.
+func acquire(ctx context.Context, sem chan struct{}) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case sem <- struct{}{}:
+	}
+	if ctx.Err() != nil {
+		// both cases were ready, the context wins.
+		<-sem
+		return false
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package owner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"k8c.io/utils/pkg/testutil"
+	"k8c.io/utils/pkg/util"
+)
+
+// recordCreatedKinds records the kinds of objects created through the returned client, safe for concurrent use.
+func recordCreatedKinds(cl client.Client) (*testutil.InterceptingClient, *[]string) {
+	var (
+		lock  sync.Mutex
+		kinds []string
+	)
+	return &testutil.InterceptingClient{
+		Client: cl,
+		CreateFunc: func(ctx context.Context, cl client.Client, obj runtime.Object, opts ...client.CreateOption) error {
+			lock.Lock()
+			kinds = append(kinds, util.ToObjectReference(obj, testScheme).Kind)
+			lock.Unlock()
+			return cl.Create(ctx, obj, opts...)
+		},
+	}, &kinds
+}
+
+func TestReconcileOwnedObjects_Concurrency(t *testing.T) {
+	ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      "ownerObj",
+		Namespace: "default",
+	}}
+	objectTypes := []runtime.Object{&corev1.Namespace{}, &corev1.ServiceAccount{}, &corev1.ConfigMap{}, &corev1.Service{}}
+	ctx := context.Background()
+
+	newClient := func(t *testing.T) client.Client {
+		cl := fakeclient.NewFakeClientWithScheme(testScheme, ownerObj)
+		for i := 0; i < 40; i++ {
+			name := fmt.Sprintf("cm-%d", i)
+			if i%2 == 0 {
+				// to be pruned.
+				name = fmt.Sprintf("obsolete-%d", i)
+			}
+			cm := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Data:       map[string]string{"value": "old"},
+			}
+			_, err := SetOwnerReference(ownerObj, cm, testScheme)
+			require.NoError(t, err)
+			require.NoError(t, cl.Create(ctx, cm))
+		}
+		return cl
+	}
+
+	desired := func() []runtime.Object {
+		var objs []runtime.Object
+		for i := 0; i < 40; i++ {
+			objs = append(objs,
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("cm-%d", i), Namespace: "default"},
+					Data:       map[string]string{"value": "new"},
+				},
+				&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("svc-%d", i), Namespace: "default"}},
+				&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("sa-%d", i), Namespace: "default"}},
+			)
+			if i < 5 {
+				objs = append(objs, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("ns-%d", i)}})
+			}
+		}
+		return objs
+	}
+	updateFn := func(obj, wantedObj runtime.Object) error {
+		if cm, ok := obj.(*corev1.ConfigMap); ok {
+			cm.Data = wantedObj.(*corev1.ConfigMap).Data
+		}
+		return nil
+	}
+
+	reconcile := func(t *testing.T, opts ...Option) (*ReconcileResult, client.Client, []string) {
+		cl, kinds := recordCreatedKinds(newClient(t))
+		result, err := ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, desired(), objectTypes, updateFn, opts...)
+		require.NoError(t, err)
+		return result, cl, *kinds
+	}
+	serialResult, serialClient, _ := reconcile(t)
+	concurrentResult, concurrentClient, concurrentKinds := reconcile(t, WithConcurrency(8))
+
+	assert.Equal(t, serialResult, concurrentResult)
+	assert.Equal(t, 20, concurrentResult.For(corev1.SchemeGroupVersion.WithKind("ConfigMap")).Deleted)
+	assert.Equal(t, 20, concurrentResult.For(corev1.SchemeGroupVersion.WithKind("ConfigMap")).Updated)
+
+	for _, cl := range []client.Client{serialClient, concurrentClient} {
+		cmLst := &corev1.ConfigMapList{}
+		require.NoError(t, cl.List(ctx, cmLst))
+		assert.Len(t, cmLst.Items, 40)
+		for _, cm := range cmLst.Items {
+			assert.Equal(t, "new", cm.Data["value"], cm.Name)
+		}
+	}
+
+	// apply groups must not overlap.
+	for i := 1; i < len(concurrentKinds); i++ {
+		assert.LessOrEqual(t, applyRank(concurrentKinds[i-1]), applyRank(concurrentKinds[i]),
+			"%s created after %s", concurrentKinds[i-1], concurrentKinds[i])
+	}
+}
+
+func TestReconcileOwnedObjects_ConcurrencyCancel(t *testing.T) {
+	ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      "ownerObj",
+		Namespace: "default",
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cl, kinds := recordCreatedKinds(fakeclient.NewFakeClientWithScheme(testScheme, ownerObj))
+	result, err := ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj,
+		[]runtime.Object{&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"}}},
+		[]runtime.Object{&corev1.ConfigMap{}}, nil, WithConcurrency(4))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Empty(t, result.Objects)
+	assert.Empty(t, *kinds)
+}
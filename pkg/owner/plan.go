@@ -0,0 +1,144 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package owner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"k8c.io/utils/pkg/util"
+)
+
+// Action is the kind of change planned for an owned object.
+type Action string
+
+// Actions of planned changes.
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+	// ActionRecreate is planned for objects changing immutable fields, see WithRecreateOnImmutableChange.
+	ActionRecreate Action = "recreate"
+)
+
+// PlannedChange is a change that would be made to a single owned object.
+type PlannedChange struct {
+	Action Action               `json:"action"`
+	Object util.ObjectReference `json:"object"`
+	// Diff lists the changed fields of updated objects.
+	Diff Diff `json:"diff,omitempty"`
+}
+
+// Plan lists the changes ReconcileOwnedObjectsOfTypes would make, in the order they would be made.
+// It is printable as text through String, and as JSON.
+type Plan struct {
+	Changes []PlannedChange `json:"changes"`
+}
+
+// Empty reports whether no changes are planned.
+func (p *Plan) Empty() bool {
+	return p == nil || len(p.Changes) == 0
+}
+
+func (p *Plan) String() string {
+	if p.Empty() {
+		return "no changes"
+	}
+	b := &strings.Builder{}
+	for _, c := range p.Changes {
+		fmt.Fprintf(b, "%s %s\n", c.Action, c.Object)
+		for _, f := range c.Diff {
+			fmt.Fprintf(b, "  %s: %s -> %s\n", f.Path, diffValue(f.Old), diffValue(f.New))
+		}
+	}
+	return b.String()
+}
+
+// PlanOwnedObjects returns the changes ReconcileOwnedObjectsOfTypes would make with the same arguments, without making them.
+//
+// Creates and updates are sent to the API server as dry-run requests,
+// so defaulting, admission and validation are part of the plan and its diffs.
+// Objects in desired Namespaces, which do not exist yet, are planned to be created without a dry-run request,
+// as the API server rejects them until their Namespace exists.
+// Deletions are computed from the owned objects alone, respecting the KeepAnnotation and WithMaxDeletions.
+func PlanOwnedObjects(ctx context.Context, cl client.Client, scheme *runtime.Scheme, ownerObj runtime.Object, desired []runtime.Object, objectTypes []runtime.Object, updateFn updateFunc, opts ...Option) (*Plan, error) {
+	plan := &Plan{}
+	o := buildOptions(opts)
+	ownerRef, err := ownerReference(ownerObj, scheme, o)
+	if err != nil {
+		return plan, err
+	}
+
+	toDelete, _, err := ownedObjectsToDelete(ctx, cl, scheme, ownerRef, desired, objectTypes, o)
+	if err != nil {
+		return plan, err
+	}
+	for _, obj := range toDelete {
+		plan.Changes = append(plan.Changes, PlannedChange{
+			Action: ActionDelete,
+			Object: util.ToObjectReference(obj, scheme),
+		})
+	}
+
+	sorted := make([]runtime.Object, 0, len(desired))
+	for _, obj := range desired {
+		// planning must not touch the desired objects of the caller.
+		sorted = append(sorted, obj.DeepCopyObject())
+	}
+	if err := sortByApplyOrder(sorted, scheme, false); err != nil {
+		return plan, err
+	}
+
+	// newNamespaces are the desired Namespaces planned to be created.
+	newNamespaces := map[string]bool{}
+	for _, obj := range sorted {
+		key := util.ToObjectReference(obj, scheme)
+		if key.Namespace != "" && newNamespaces[key.Namespace] {
+			plan.Changes = append(plan.Changes, PlannedChange{Action: ActionCreate, Object: key})
+			continue
+		}
+		op, diff, err := applyObject(ctx, cl, scheme, ownerObj, obj, updateFn, opts, o, true)
+		if err == errUpToDate {
+			continue
+		}
+		if err != nil && o.skipConflicts && IsOwnershipConflict(err) {
+			continue
+		}
+		if err != nil && op == controllerutil.OperationResultUpdated && isImmutableFieldError(err) && o.recreates(obj, scheme) {
+			plan.Changes = append(plan.Changes, PlannedChange{Action: ActionRecreate, Object: key})
+			continue
+		}
+		if err != nil {
+			return plan, fmt.Errorf("planning %s: %w", key, err)
+		}
+		switch op {
+		case controllerutil.OperationResultCreated:
+			plan.Changes = append(plan.Changes, PlannedChange{Action: ActionCreate, Object: key})
+			if key.Group == "" && key.Kind == "Namespace" {
+				newNamespaces[key.Name] = true
+			}
+		case controllerutil.OperationResultUpdated:
+			plan.Changes = append(plan.Changes, PlannedChange{Action: ActionUpdate, Object: key, Diff: diff})
+		}
+	}
+	return plan, nil
+}
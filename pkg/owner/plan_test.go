@@ -0,0 +1,155 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package owner
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"k8c.io/utils/pkg/testutil"
+	"k8c.io/utils/pkg/util"
+)
+
+func TestPlanOwnedObjects(t *testing.T) {
+	ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      "ownerObj",
+		Namespace: "default",
+	}}
+	cmA := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cma", Namespace: "default"},
+		Data:       map[string]string{"cm": "a"},
+	}
+	cmB := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cmb", Namespace: "default"},
+		Data:       map[string]string{"cm": "b"},
+	}
+	cmC := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cmc", Namespace: "default"},
+		Data:       map[string]string{"cm": "c"},
+	}
+
+	ctx := context.Background()
+	cl := fakeclient.NewFakeClientWithScheme(testScheme, ownerObj)
+	for _, obj := range []*corev1.ConfigMap{cmA, cmB} {
+		obj = obj.DeepCopy()
+		_, err := SetOwnerReference(ownerObj, obj, testScheme)
+		require.NoError(t, err)
+		require.NoError(t, cl.Create(ctx, obj))
+	}
+
+	cmAPrime := cmA.DeepCopy()
+	cmAPrime.Data["cm"] = "a prime"
+	desired := []runtime.Object{cmAPrime, cmC.DeepCopy()}
+	plan, err := PlanOwnedObjects(ctx, cl, testScheme, ownerObj, desired, []runtime.Object{&corev1.ConfigMap{}}, func(obj, wantedObj runtime.Object) error {
+		obj.(*corev1.ConfigMap).Data = wantedObj.(*corev1.ConfigMap).Data
+		return nil
+	})
+	require.NoError(t, err)
+
+	var actions []string
+	for _, c := range plan.Changes {
+		actions = append(actions, string(c.Action)+" "+c.Object.Name)
+	}
+	assert.Equal(t, []string{"delete cmb", "update cma", "create cmc"}, actions)
+	assert.Equal(t, Diff{{Path: "data.cm", Old: "a", New: "a prime"}}, plan.Changes[1].Diff)
+	assert.Equal(t, `delete ConfigMap./default:cmb
+update ConfigMap./default:cma
+  data.cm: "a" -> "a prime"
+create ConfigMap./default:cmc
+`, plan.String())
+
+	b, err := json.Marshal(plan)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `{"action":"update","object":{"name":"cma","namespace":"default","group":"","kind":"ConfigMap"},"diff":[{"path":"data.cm","old":"a","new":"a prime"}]}`)
+
+	// the plan must not change anything.
+	assert.Equal(t, "a prime", cmAPrime.Data["cm"], "desired objects must not be modified")
+	cmLst := &corev1.ConfigMapList{}
+	require.NoError(t, cl.List(ctx, cmLst))
+	assert.Len(t, cmLst.Items, 2)
+	live := &corev1.ConfigMap{}
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "default", Name: "cma"}, live))
+	assert.Equal(t, "a", live.Data["cm"])
+
+	plan, err = PlanOwnedObjects(ctx, cl, testScheme, ownerObj, []runtime.Object{cmA.DeepCopy(), cmB.DeepCopy()}, []runtime.Object{&corev1.ConfigMap{}}, nil)
+	require.NoError(t, err)
+	assert.True(t, plan.Empty())
+	assert.Equal(t, "no changes", plan.String())
+}
+
+func TestPlanOwnedObjects_NewNamespace(t *testing.T) {
+	ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      "ownerObj",
+		Namespace: "default",
+	}}
+	ctx := context.Background()
+
+	// creates records the dry-run creates, which fail for missing namespaces like in the API server.
+	var creates []string
+	cl := &testutil.InterceptingClient{
+		Client: fakeclient.NewFakeClientWithScheme(testScheme, ownerObj),
+		CreateFunc: func(ctx context.Context, cl client.Client, obj runtime.Object, opts ...client.CreateOption) error {
+			ref := util.ToObjectReference(obj, testScheme)
+			creates = append(creates, ref.Kind+" "+ref.Name)
+			if ref.Namespace != "" {
+				if err := cl.Get(ctx, types.NamespacedName{Name: ref.Namespace}, &corev1.Namespace{}); err != nil {
+					return err
+				}
+			}
+			return cl.Create(ctx, obj, opts...)
+		},
+	}
+
+	desired := []runtime.Object{
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "new"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "new"}},
+	}
+	plan, err := PlanOwnedObjects(ctx, cl, testScheme, ownerObj, desired, []runtime.Object{&corev1.Namespace{}, &corev1.ConfigMap{}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, `create Namespace./:new
+create ConfigMap./new:cm
+`, plan.String())
+	assert.Equal(t, []string{"Namespace new"}, creates)
+}
+
+func TestDiffObjects(t *testing.T) {
+	old := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default", ResourceVersion: "1"},
+		Data:       map[string]string{"removed": "x", "kept": "y"},
+	}
+	new := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default", ResourceVersion: "2", Labels: map[string]string{"app": "test"}},
+		Data:       map[string]string{"kept": "y"},
+	}
+
+	diff, err := diffObjects(old, new)
+	require.NoError(t, err)
+	assert.Equal(t, Diff{
+		{Path: "data.removed", Old: "x"},
+		{Path: "metadata.labels.app", New: "test"},
+	}, diff)
+}
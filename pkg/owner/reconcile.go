@@ -23,10 +23,15 @@ import (
 	"fmt"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
-	controllerruntime "sigs.k8s.io/controller-runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	"k8c.io/utils/pkg/util"
@@ -42,10 +47,213 @@ type updateFunc func(actual, desired runtime.Object) error
 // are wanted. Also this would only operate on the kubernetes objects objectType GroupKind.
 // In case object already exists in the kubernetes cluster the updateFn function is called allowing the user fixing
 // between found and wanted object. In case the function is nil it's ignored.
-func ReconcileOwnedObjects(ctx context.Context, cl client.Client, log logr.Logger, scheme *runtime.Scheme, ownerObj runtime.Object, desired []runtime.Object, objectType runtime.Object, updateFn updateFunc) (changed bool, err error) {
-	existing, err := util.ListObjects(ctx, cl, scheme, []runtime.Object{objectType}, OwnedBy(ownerObj, scheme))
+// Existing objects without owner are only adopted with WithAdoptPolicy(AdoptUnowned),
+// otherwise an OwnershipConflictError is returned.
+// Options are passed down to SetOwnerReference and OwnedBy.
+func ReconcileOwnedObjects(ctx context.Context, cl client.Client, log logr.Logger, scheme *runtime.Scheme, ownerObj runtime.Object, desired []runtime.Object, objectType runtime.Object, updateFn updateFunc, opts ...Option) (changed bool, err error) {
+	result, err := ReconcileOwnedObjectsOfTypes(ctx, cl, log, scheme, ownerObj, desired, []runtime.Object{objectType}, updateFn, opts...)
+	return result.Changed(), err
+}
+
+// ReconcileOwnedObjectsOfTypes works like ReconcileOwnedObjects, but for desired objects of different types.
+// Owned objects of all objectTypes, which are not desired, are removed.
+//
+// Types unknown to the scheme, e.g. custom resources of rendered manifests, are passed as *unstructured.Unstructured
+// objects and type hints with their GVK set. Typed and unstructured objects can be mixed,
+// objects are matched by their GVK, namespace and name.
+//
+// Desired objects are created and updated in dependency order,
+// e.g. Namespaces before CustomResourceDefinitions, RBAC, configuration and workloads.
+// Objects are deleted in reverse order.
+//
+// Owned objects are only deleted, if they did not change since they were listed.
+// Objects with the KeepAnnotation, or which changed meanwhile, are not deleted and reported as skipped.
+// WithMaxDeletions and WithPropagationPolicy further control pruning.
+//
+// With WithServerSideApply, desired objects are sent as server-side apply patches instead.
+//
+// With WithConcurrency, objects of the same apply group are processed in parallel.
+//
+// With WithNativeOwnerReference, objects in the namespace of the owner also get a metav1.OwnerReference.
+//
+// With WithRecreateOnImmutableChange, objects rejecting updates of immutable fields are deleted and created again.
+//
+// With WithDesiredStateHash, existing objects are not written, if their desired state did not change.
+//
+// With WithSkipConflicts, desired objects owned by someone else are skipped and reported in ReconcileResult.Conflicts.
+//
+// The result holds the operation and error of every object. Failing objects do not stop the reconciliation,
+// their errors are returned as ReconcileError. With WithEventRecorder, changes and failures are recorded as Events on the owner.
+func ReconcileOwnedObjectsOfTypes(ctx context.Context, cl client.Client, log logr.Logger, scheme *runtime.Scheme, ownerObj runtime.Object, desired []runtime.Object, objectTypes []runtime.Object, updateFn updateFunc, opts ...Option) (*ReconcileResult, error) {
+	result := &ReconcileResult{}
+	o := buildOptions(opts)
+	ownerRef, err := ownerReference(ownerObj, scheme, o)
+	if err != nil {
+		return result, err
+	}
+	for _, objType := range objectTypes {
+		if _, err := result.forObject(objType, scheme); err != nil {
+			return result, err
+		}
+	}
+
+	toDelete, kept, err := ownedObjectsToDelete(ctx, cl, scheme, ownerRef, desired, objectTypes, o)
+	if err != nil {
+		return result, err
+	}
+
+	r := &reporter{
+		result:   result,
+		log:      log,
+		recorder: o.eventRecorder,
+		scheme:   scheme,
+		owner:    ownerObj,
+	}
+	for _, obj := range kept {
+		if err := r.report(obj, outcome{op: OperationSkipped, reason: "kept by annotation " + KeepAnnotation}); err != nil {
+			return result, err
+		}
+	}
+
+	err = forEachInApplyGroups(ctx, toDelete, scheme, o.workers, func(obj runtime.Object) outcome {
+		return deleteObject(ctx, cl, obj, o)
+	}, r.report)
 	if err != nil {
-		return false, fmt.Errorf("ListObjects: %w", err)
+		return result, r.aggregate(err)
+	}
+
+	desired = append([]runtime.Object(nil), desired...)
+	if err := sortByApplyOrder(desired, scheme, false); err != nil {
+		return result, err
+	}
+
+	err = forEachInApplyGroups(ctx, desired, scheme, o.workers, func(obj runtime.Object) outcome {
+		op, diff, err := applyOrRecreate(ctx, cl, scheme, ownerObj, obj, updateFn, opts, o)
+		if err == errUpToDate {
+			return outcome{op: OperationUnchanged, writeSkipped: true}
+		}
+		if conflict := asOwnershipConflict(err); o.skipConflicts && conflict != nil {
+			return outcome{op: OperationSkipped, reason: "ownership conflict: " + conflict.Error(), conflict: conflict}
+		}
+		return outcome{op: op, diff: diff, err: err}
+	}, r.report)
+	if err != nil {
+		return result, r.aggregate(err)
+	}
+	return result, r.aggregate(nil)
+}
+
+// deleteObject prunes a single owned object.
+func deleteObject(ctx context.Context, cl client.Client, obj runtime.Object, o *options) outcome {
+	deleteOpts, err := deleteOptions(obj, o)
+	if err == nil {
+		err = cl.Delete(ctx, obj, deleteOpts...)
+	}
+	switch {
+	case err == nil:
+		return outcome{op: OperationDeleted}
+	case errors.IsNotFound(err):
+		return outcome{op: OperationUnchanged}
+	case errors.IsConflict(err):
+		// the preconditions failed, the object is reconsidered in the next run.
+		return outcome{op: OperationSkipped, reason: "changed since it was listed: " + err.Error()}
+	default:
+		return outcome{op: OperationDeleted, err: fmt.Errorf("deleting %v: %w", obj, err)}
+	}
+}
+
+// operationFor converts the result of CreateOrUpdate.
+func operationFor(op controllerutil.OperationResult) Operation {
+	switch op {
+	case controllerutil.OperationResultCreated:
+		return OperationCreated
+	case controllerutil.OperationResultUpdated:
+		return OperationUpdated
+	default:
+		return OperationUnchanged
+	}
+}
+
+// eventReasons are the reasons of events for changed objects.
+var eventReasons = map[Operation]string{
+	OperationCreated:   "OwnedObjectCreated",
+	OperationUpdated:   "OwnedObjectUpdated",
+	OperationDeleted:   "OwnedObjectDeleted",
+	OperationRecreated: "OwnedObjectRecreated",
+}
+
+// reporter records the results of single objects, and reports them through logs and events.
+type reporter struct {
+	result   *ReconcileResult
+	log      logr.Logger
+	recorder record.EventRecorder
+	scheme   *runtime.Scheme
+	owner    runtime.Object
+	errs     []error
+}
+
+// aggregate returns the errors of all objects and err, if any.
+func (r *reporter) aggregate(err error) error {
+	errs := r.errs
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ReconcileError{Errors: errs}
+}
+
+func (r *reporter) report(obj runtime.Object, out outcome) error {
+	op, reason, diff, err := out.op, out.reason, out.diff, out.err
+	key := util.ToObjectReference(obj, r.scheme)
+	if err := r.result.add(obj, r.scheme, ObjectResult{
+		Object:       key,
+		Operation:    op,
+		Reason:       reason,
+		Error:        err,
+		Conflict:     out.conflict,
+		WriteSkipped: out.writeSkipped,
+	}); err != nil {
+		return err
+	}
+	if err != nil {
+		r.errs = append(r.errs, err)
+	}
+
+	if r.log != nil {
+		keysAndValues := []interface{}{"group", key.Group, "kind", key.Kind, "name", key.Name, "namespace", key.Namespace}
+		switch {
+		case err != nil:
+			keysAndValues = append(keysAndValues, "error", err.Error())
+		case reason != "":
+			keysAndValues = append(keysAndValues, "reason", reason)
+		case len(diff) > 0:
+			keysAndValues = append(keysAndValues, "diff", diff.String())
+		}
+		r.log.V(6).Info("object "+string(op), keysAndValues...)
+	}
+
+	if r.recorder == nil {
+		return nil
+	}
+	switch {
+	case err != nil:
+		r.recorder.Eventf(r.owner, corev1.EventTypeWarning, "OwnedObjectFailed", "%s %s failed: %v", key, op, err)
+	case op == OperationSkipped:
+		r.recorder.Eventf(r.owner, corev1.EventTypeNormal, "OwnedObjectSkipped", "%s %s: %s", key, op, reason)
+	case op != OperationUnchanged:
+		r.recorder.Eventf(r.owner, corev1.EventTypeNormal, eventReasons[op], "%s %s", key, op)
+	}
+	return nil
+}
+
+// ownedObjectsToDelete returns the owned objects of objectTypes that are not desired, in deletion order.
+// Objects with the KeepAnnotation are returned as kept.
+func ownedObjectsToDelete(ctx context.Context, cl client.Client, scheme *runtime.Scheme, ownerRef util.ObjectReference, desired []runtime.Object, objectTypes []runtime.Object, o *options) (toDelete, kept []runtime.Object, err error) {
+	existing, err := listOwnedObjects(ctx, cl, scheme, ownerRef, objectTypes, o)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	wantedMap := make(map[util.ObjectReference]runtime.Object)
@@ -55,48 +263,309 @@ func ReconcileOwnedObjects(ctx context.Context, cl client.Client, log logr.Logge
 
 	for _, obj := range existing {
 		key := util.ToObjectReference(obj, scheme)
-		if _, shouldExists := wantedMap[key]; !shouldExists {
-			err := cl.Delete(ctx, obj)
-			switch {
-			case err == nil:
-				changed = true
-				if log != nil {
-					log.V(6).Info("object deleted", "group", key.Group, "kind", key.Kind, "name", key.Name, "namespace", key.Namespace)
-				}
-			case errors.IsNotFound(err):
-				break
-			default:
-				return changed, fmt.Errorf("deleting %v: %w", obj, err)
-			}
+		if _, shouldExists := wantedMap[key]; shouldExists {
+			continue
+		}
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return nil, nil, err
+		}
+		if accessor.GetAnnotations()[KeepAnnotation] == "true" {
+			kept = append(kept, obj)
+			continue
+		}
+		toDelete = append(toDelete, obj)
+	}
+	if o.maxDeletions != nil && len(toDelete) > *o.maxDeletions {
+		return nil, kept, fmt.Errorf("%w: %d objects to delete, at most %d are allowed", ErrMaxDeletionsExceeded, len(toDelete), *o.maxDeletions)
+	}
+	if err := sortByApplyOrder(toDelete, scheme, true); err != nil {
+		return nil, kept, err
+	}
+	return toDelete, kept, nil
+}
+
+// deleteOptions makes sure only the listed object is deleted, and not a changed or recreated one.
+func deleteOptions(obj runtime.Object, o *options) ([]client.DeleteOption, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	preconditions := client.Preconditions{}
+	if uid := accessor.GetUID(); uid != "" {
+		preconditions.UID = &uid
+	}
+	if resourceVersion := accessor.GetResourceVersion(); resourceVersion != "" {
+		preconditions.ResourceVersion = &resourceVersion
+	}
+	deleteOpts := []client.DeleteOption{preconditions}
+	if o.propagationPolicy != "" {
+		deleteOpts = append(deleteOpts, client.PropagationPolicy(o.propagationPolicy))
+	}
+	return deleteOpts, nil
+}
+
+// applyObject creates or updates the desired object, either through createOrUpdate or server-side apply.
+// The returned diff lists the changes made to an existing object.
+func applyObject(ctx context.Context, cl client.Client, scheme *runtime.Scheme, ownerObj, obj runtime.Object, updateFn updateFunc, opts []Option, o *options, dryRun bool) (controllerutil.OperationResult, Diff, error) {
+	var hash string
+	if o.desiredStateHash {
+		var err error
+		if hash, err = desiredStateHash(obj); err != nil {
+			return controllerutil.OperationResultNone, nil, err
 		}
 	}
+	if o.fieldManager != "" {
+		return serverSideApply(ctx, cl, scheme, ownerObj, obj, opts, o, hash, dryRun)
+	}
 
-	for _, obj := range desired {
-		// ctrl.CreateOrUpdate shall override obj with the current k8s value, thus we're performing a
-		// deep copy to preserve wanted object data
-		wantedObj := obj.DeepCopyObject()
-		op, err := controllerruntime.CreateOrUpdate(ctx, cl, obj, func() error {
-			_, err := SetOwnerReference(ownerObj, obj, scheme)
+	// createOrUpdate shall override obj with the current k8s value, thus we're performing a
+	// deep copy to preserve wanted object data
+	wantedObj := obj.DeepCopyObject()
+	op, diff, err := createOrUpdate(ctx, cl, obj, func(exists bool) error {
+		if exists {
+			if err := checkAdoption(ownerObj, obj, scheme, o); err != nil {
+				return err
+			}
+		}
+		ownerChanged, err := SetOwnerReference(ownerObj, obj, scheme, opts...)
+		if err != nil {
+			return fmt.Errorf("setting owner ref %v: %w", obj, err)
+		}
+		if exists && hash != "" && !ownerChanged {
+			ok, err := upToDate(obj, wantedObj, hash)
 			if err != nil {
-				return fmt.Errorf("setting owner ref %v: %w", obj, err)
+				return err
+			}
+			if ok {
+				return errUpToDate
 			}
-			if updateFn != nil {
-				return updateFn(obj, wantedObj)
+		}
+		if updateFn != nil {
+			if err := updateFn(obj, wantedObj); err != nil {
+				return err
 			}
-			return nil
-		})
-		if op != controllerutil.OperationResultNone {
-			changed = true
 		}
+		if hash != "" {
+			return setDesiredStateHash(obj, hash)
+		}
+		return nil
+	}, dryRun)
+	if err == errUpToDate {
+		return controllerutil.OperationResultNone, nil, err
+	}
+	if err != nil {
+		return op, nil, fmt.Errorf("create or deleting %v: %w", obj, err)
+	}
+	return op, diff, nil
+}
+
+// createOrUpdate works like controllerutil.CreateOrUpdate,
+// but supports dry-run and returns the changes made to an existing object.
+func createOrUpdate(ctx context.Context, cl client.Client, obj runtime.Object, mutate func(exists bool) error, dryRun bool) (controllerutil.OperationResult, Diff, error) {
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return controllerutil.OperationResultNone, nil, err
+	}
+
+	if err := cl.Get(ctx, key, obj); err != nil {
+		if !errors.IsNotFound(err) {
+			return controllerutil.OperationResultNone, nil, err
+		}
+		if err := mutate(false); err != nil {
+			return controllerutil.OperationResultCreated, nil, err
+		}
+		var createOpts []client.CreateOption
+		if dryRun {
+			createOpts = append(createOpts, client.DryRunAll)
+		}
+		if err := cl.Create(ctx, obj, createOpts...); err != nil {
+			return controllerutil.OperationResultCreated, nil, err
+		}
+		return controllerutil.OperationResultCreated, nil, nil
+	}
+
+	existing := obj.DeepCopyObject()
+	if err := mutate(true); err != nil {
+		return controllerutil.OperationResultUpdated, nil, err
+	}
+	if newKey, err := client.ObjectKeyFromObject(obj); err != nil || newKey != key {
+		return controllerutil.OperationResultUpdated, nil, fmt.Errorf("MutateFn cannot mutate object name and/or object namespace")
+	}
+	if equality.Semantic.DeepEqual(existing, obj) {
+		return controllerutil.OperationResultNone, nil, nil
+	}
+
+	var updateOpts []client.UpdateOption
+	if dryRun {
+		updateOpts = append(updateOpts, client.DryRunAll)
+	}
+	if err := cl.Update(ctx, obj, updateOpts...); err != nil {
+		return controllerutil.OperationResultUpdated, nil, err
+	}
+	diff, err := diffObjects(existing, obj)
+	if err != nil {
+		return controllerutil.OperationResultUpdated, nil, err
+	}
+	return controllerutil.OperationResultUpdated, diff, nil
+}
+
+// serverSideApply sends the desired object as server-side apply patch.
+// Whether the object was changed is deduced from its resourceVersion and the changed fields.
+func serverSideApply(ctx context.Context, cl client.Client, scheme *runtime.Scheme, ownerObj, obj runtime.Object, opts []Option, o *options, hash string, dryRun bool) (controllerutil.OperationResult, Diff, error) {
+	if _, err := SetOwnerReference(ownerObj, obj, scheme, opts...); err != nil {
+		return controllerutil.OperationResultNone, nil, fmt.Errorf("setting owner ref %v: %w", obj, err)
+	}
+	gvk, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		return controllerutil.OperationResultNone, nil, fmt.Errorf("cannot get GVK for %T: %w", obj, err)
+	}
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return controllerutil.OperationResultNone, nil, err
+	}
 
+	var liveResourceVersion string
+	live := obj.DeepCopyObject()
+	err = cl.Get(ctx, key, live)
+	switch {
+	case errors.IsNotFound(err):
+		live = nil
+	case err != nil:
+		return controllerutil.OperationResultNone, nil, fmt.Errorf("getting %s: %w", util.MustLogLine(obj, scheme), err)
+	default:
+		liveAccessor, err := meta.Accessor(live)
 		if err != nil {
-			return changed, fmt.Errorf("create or deleting %v: %w", obj, err)
+			return controllerutil.OperationResultNone, nil, err
+		}
+		liveResourceVersion = liveAccessor.GetResourceVersion()
+		if err := checkAdoption(ownerObj, live, scheme, o); err != nil {
+			return controllerutil.OperationResultUpdated, nil, err
+		}
+		// an apply would silently take over objects owned by someone else.
+		if _, err := SetOwnerReference(ownerObj, live.DeepCopyObject(), scheme, opts...); err != nil {
+			return controllerutil.OperationResultUpdated, nil, fmt.Errorf("setting owner ref %v: %w", obj, err)
+		}
+		if hash != "" {
+			// obj carries the owner reference already, so a changed owner counts as drift.
+			ok, err := upToDate(live, obj, hash)
+			if err != nil {
+				return controllerutil.OperationResultNone, nil, err
+			}
+			if ok {
+				return controllerutil.OperationResultNone, nil, errUpToDate
+			}
+		}
+	}
+	if hash != "" {
+		if err := setDesiredStateHash(obj, hash); err != nil {
+			return controllerutil.OperationResultNone, nil, err
+		}
+	}
+
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return controllerutil.OperationResultNone, nil, err
+	}
+	// apply patches must carry their type and must not carry server-owned metadata.
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	accessor.SetResourceVersion("")
+	accessor.SetManagedFields(nil)
+
+	patchOpts := []client.PatchOption{client.FieldOwner(o.fieldManager)}
+	if o.forceConflicts {
+		patchOpts = append(patchOpts, client.ForceOwnership)
+	}
+	if dryRun {
+		patchOpts = append(patchOpts, client.DryRunAll)
+	}
+	if err := cl.Patch(ctx, obj, client.Apply, patchOpts...); err != nil {
+		op := controllerutil.OperationResultUpdated
+		if live == nil {
+			op = controllerutil.OperationResultCreated
 		}
+		return op, nil, fmt.Errorf("applying %s: %w", util.MustLogLine(obj, scheme), err)
+	}
 
-		key := util.ToObjectReference(obj, scheme)
-		if log != nil {
-			log.V(6).Info("object "+string(op), "group", key.Group, "kind", key.Kind, "name", key.Name, "namespace", key.Namespace)
+	if live == nil {
+		return controllerutil.OperationResultCreated, nil, nil
+	}
+	diff, err := diffObjects(live, obj)
+	if err != nil {
+		return controllerutil.OperationResultNone, nil, err
+	}
+	// dry-run requests do not bump the resourceVersion.
+	if accessor.GetResourceVersion() != liveResourceVersion || len(diff) > 0 {
+		return controllerutil.OperationResultUpdated, diff, nil
+	}
+	return controllerutil.OperationResultNone, nil, nil
+}
+
+// checkAdoption returns an error, if the existing object is owned by another owner,
+// or is not owned or owned by a previous owner and must not be adopted.
+func checkAdoption(ownerObj, existing runtime.Object, scheme *runtime.Scheme, o *options) error {
+	accessor, err := meta.Accessor(existing)
+	if err != nil {
+		return err
+	}
+	ownerRef, err := ownerReference(ownerObj, scheme, o)
+	if err != nil {
+		return err
+	}
+	existingRef, owned, err := referenceFromObject(accessor)
+	if err != nil {
+		return err
+	}
+	if owned && existingRef != ownerRef {
+		// objects of other owners are never adopted.
+		return &OwnershipConflictError{
+			Object:    util.ToObjectReference(existing, scheme),
+			Existing:  existingRef,
+			Requested: ownerRef,
+		}
+	}
+	if o.adoptPolicy == AdoptUnowned {
+		return nil
+	}
+	if owned {
+		return CheckOwnerUID(ownerObj, existing, scheme)
+	}
+	return &OwnershipConflictError{
+		Object:    util.ToObjectReference(existing, scheme),
+		Requested: ownerRef,
+	}
+}
+
+// listOwnedObjects lists all objects of the given types owned by the referenced owner.
+// With hashed labels, objects still carrying plain owner labels are listed as well, so they can be migrated.
+func listOwnedObjects(ctx context.Context, cl client.Client, scheme *runtime.Scheme, ownerRef util.ObjectReference, objectTypes []runtime.Object, o *options) ([]runtime.Object, error) {
+	existing, err := util.ListObjects(ctx, cl, scheme, objectTypes, ownedBy(ownerRef, o))
+	if err != nil {
+		return nil, fmt.Errorf("ListObjects: %w", err)
+	}
+	if !o.hashedLabels {
+		return existing, nil
+	}
+	for _, value := range plainLabels(ownerRef) {
+		if len(validation.IsValidLabelValue(value)) > 0 {
+			// such owners could never be recorded in plain labels,
+			// and an invalid selector would match all objects.
+			return existing, nil
+		}
+	}
+
+	plain, err := util.ListObjects(ctx, cl, scheme, objectTypes, ownedBy(ownerRef, &options{restMapper: o.restMapper}))
+	if err != nil {
+		return nil, fmt.Errorf("ListObjects: %w", err)
+	}
+	seen := make(map[util.ObjectReference]struct{}, len(existing))
+	for _, obj := range existing {
+		seen[util.ToObjectReference(obj, scheme)] = struct{}{}
+	}
+	for _, obj := range plain {
+		if _, ok := seen[util.ToObjectReference(obj, scheme)]; !ok {
+			existing = append(existing, obj)
 		}
 	}
-	return changed, nil
+	return existing, nil
 }
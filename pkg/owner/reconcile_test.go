@@ -18,14 +18,23 @@ package owner
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	"k8c.io/utils/pkg/testutil"
@@ -67,6 +76,7 @@ func TestOwnedObjectReconciler_Reconcile(t *testing.T) {
 		wantedState   []runtime.Object
 		finalState    []*corev1.ConfigMap
 		muateFn       func(obj, wantedObj runtime.Object) error
+		opts          []Option
 		change        bool
 	}{
 		"clearing": {
@@ -101,41 +111,603 @@ func TestOwnedObjectReconciler_Reconcile(t *testing.T) {
 			},
 			change: true,
 		},
+		"migrate to hashed labels": {
+			existingState: []runtime.Object{cmA.DeepCopy(), cmB.DeepCopy()},
+			wantedState:   []runtime.Object{cmA.DeepCopy()},
+			finalState:    []*corev1.ConfigMap{cmA.DeepCopy()},
+			opts:          []Option{WithHashedLabels()},
+			change:        true,
+		},
 	} {
-		t.Run(name, func(t *testing.T) {
-			ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		for scope, ownerObj := range map[string]runtime.Object{
+			"namespaced": &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
 				Name:      "ownerObj",
 				Namespace: "default",
-			}}
-			cl := fakeclient.NewFakeClientWithScheme(testScheme, ownerObj)
-			ctx := context.Background()
-			for _, obj := range testCase.existingState {
-				_, err := SetOwnerReference(ownerObj, obj, testScheme)
-				require.NoError(t, err)
-				require.NoError(t, cl.Create(ctx, obj))
+			}},
+			"cluster-scoped": &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name: "ownerObj",
+			}},
+		} {
+			testCase, ownerObj := testCase, ownerObj
+			t.Run(scope+"/"+name, func(t *testing.T) {
+				opts := append([]Option{WithRESTMapper(testRESTMapper)}, testCase.opts...)
+				cl := fakeclient.NewFakeClientWithScheme(testScheme, ownerObj)
+				ctx := context.Background()
+				for _, obj := range testCase.existingState {
+					obj = obj.DeepCopyObject()
+					_, err := SetOwnerReference(ownerObj, obj, testScheme, WithRESTMapper(testRESTMapper))
+					require.NoError(t, err)
+					require.NoError(t, cl.Create(ctx, obj))
+				}
+				var wantedState []runtime.Object
+				for _, obj := range testCase.wantedState {
+					wantedState = append(wantedState, obj.DeepCopyObject())
+				}
+				changed, err := ReconcileOwnedObjects(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, wantedState, &corev1.ConfigMap{}, testCase.muateFn, opts...)
+				assert.NoError(t, err)
+				assert.Equal(t, testCase.change, changed)
+
+				cmLst := &corev1.ConfigMapList{}
+				require.NoError(t, cl.List(ctx, cmLst))
+				wants := make(map[util.ObjectReference]struct{})
+				for _, obj := range testCase.finalState {
+					wants[util.ToObjectReference(obj, testScheme)] = struct{}{}
+					cm := &corev1.ConfigMap{}
+					if assert.NoError(t, cl.Get(ctx, types.NamespacedName{
+						Namespace: obj.Namespace,
+						Name:      obj.Name,
+					}, cm)) {
+						assert.Equal(t, obj.Data, cm.Data)
+						assert.Equal(t, labelsForReference(util.ToObjectReference(ownerObj, testScheme), buildOptions(opts))[OwnerNameLabel], cm.Labels[OwnerNameLabel])
+					}
+				}
+				got := make(map[util.ObjectReference]struct{})
+				for _, obj := range cmLst.Items {
+					got[util.ToObjectReference(&obj, testScheme)] = struct{}{}
+				}
+				assert.Equal(t, wants, got, "some object exist and shouldn't or vice versa")
+			})
+		}
+	}
+}
+
+func TestReconcileOwnedObjectsOfTypes(t *testing.T) {
+	ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      "ownerObj",
+		Namespace: "default",
+	}}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns"}}
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "ns"}}
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "ns"}}
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "sa", Namespace: "ns"}}
+	objectTypes := []runtime.Object{&corev1.Service{}, &corev1.ConfigMap{}, &corev1.Namespace{}, &corev1.ServiceAccount{}}
+
+	ctx := context.Background()
+	// ops records the order of create and delete calls.
+	var ops []string
+	cl := &testutil.InterceptingClient{
+		Client: fakeclient.NewFakeClientWithScheme(testScheme, ownerObj),
+		CreateFunc: func(ctx context.Context, cl client.Client, obj runtime.Object, opts ...client.CreateOption) error {
+			ops = append(ops, "create "+util.ToObjectReference(obj, testScheme).Kind)
+			return cl.Create(ctx, obj, opts...)
+		},
+		DeleteFunc: func(ctx context.Context, cl client.Client, obj runtime.Object, opts ...client.DeleteOption) error {
+			ops = append(ops, "delete "+util.ToObjectReference(obj, testScheme).Kind)
+			return cl.Delete(ctx, obj, opts...)
+		},
+	}
+
+	result, err := ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj,
+		[]runtime.Object{svc.DeepCopy(), cm.DeepCopy(), sa.DeepCopy(), ns.DeepCopy()}, objectTypes, nil)
+	require.NoError(t, err)
+	assert.True(t, result.Changed())
+	assert.Equal(t, []string{"create Namespace", "create ServiceAccount", "create ConfigMap", "create Service"}, ops)
+
+	var kinds []string
+	for _, typeResult := range result.Types {
+		kinds = append(kinds, typeResult.GroupVersionKind.Kind)
+		assert.Equal(t, 1, typeResult.Created, typeResult.GroupVersionKind.Kind)
+	}
+	assert.Equal(t, []string{"Namespace", "ServiceAccount", "ConfigMap", "Service"}, kinds)
+
+	ops = nil
+	result, err = ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj,
+		[]runtime.Object{cm.DeepCopy()}, objectTypes, nil)
+	require.NoError(t, err)
+	assert.True(t, result.Changed())
+	assert.Equal(t, []string{"delete Service", "delete ServiceAccount", "delete Namespace"}, ops)
+	assert.Equal(t, 1, result.For(corev1.SchemeGroupVersion.WithKind("Namespace")).Deleted)
+	assert.False(t, result.For(corev1.SchemeGroupVersion.WithKind("ConfigMap")).Changed())
+
+	ops = nil
+	result, err = ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj,
+		[]runtime.Object{cm.DeepCopy()}, objectTypes, nil)
+	require.NoError(t, err)
+	assert.False(t, result.Changed())
+	assert.Empty(t, ops)
+}
+
+func TestReconcileOwnedObjects_ServerSideApply(t *testing.T) {
+	ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      "ownerObj",
+		Namespace: "default",
+	}}
+	cmA := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cma", Namespace: "default"},
+		Data:       map[string]string{"cm": "a"},
+	}
+	cmB := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cmb", Namespace: "default"},
+		Data:       map[string]string{"cm": "b"},
+	}
+
+	ctx := context.Background()
+	// the patch hook emulates server-side apply of ConfigMaps, which the fake client does not support.
+	var (
+		fieldManagers []string
+		force         []bool
+	)
+	cl := &testutil.InterceptingClient{
+		Client: fakeclient.NewFakeClientWithScheme(testScheme, ownerObj),
+		PatchFunc: func(ctx context.Context, cl client.Client, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if patch.Type() != types.ApplyPatchType {
+				return cl.Patch(ctx, obj, patch, opts...)
 			}
-			changed, err := ReconcileOwnedObjects(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, testCase.wantedState, &corev1.ConfigMap{}, testCase.muateFn)
-			assert.NoError(t, err)
-			assert.Equal(t, testCase.change, changed)
-
-			cmLst := &corev1.ConfigMapList{}
-			require.NoError(t, cl.List(ctx, cmLst))
-			wants := make(map[util.ObjectReference]struct{})
-			for _, obj := range testCase.finalState {
-				wants[util.ToObjectReference(obj, testScheme)] = struct{}{}
-				cm := &corev1.ConfigMap{}
-				if assert.NoError(t, cl.Get(ctx, types.NamespacedName{
-					Namespace: obj.Namespace,
-					Name:      obj.Name,
-				}, cm)) {
-					assert.Equal(t, obj.Data, cm.Data)
+			patchOpts := &client.PatchOptions{}
+			patchOpts.ApplyOptions(opts)
+			fieldManagers = append(fieldManagers, patchOpts.FieldManager)
+			force = append(force, patchOpts.Force != nil && *patchOpts.Force)
+
+			applied := obj.(*corev1.ConfigMap)
+			live := &corev1.ConfigMap{}
+			err := cl.Get(ctx, types.NamespacedName{Namespace: applied.Namespace, Name: applied.Name}, live)
+			if apierrors.IsNotFound(err) {
+				return cl.Create(ctx, applied)
+			}
+			if err != nil {
+				return err
+			}
+
+			changed := false
+			for k, v := range applied.Labels {
+				if live.Labels[k] != v {
+					changed = true
+					if live.Labels == nil {
+						live.Labels = map[string]string{}
+					}
+					live.Labels[k] = v
+				}
+			}
+			for k, v := range applied.Data {
+				if live.Data[k] != v {
+					changed = true
+					if live.Data == nil {
+						live.Data = map[string]string{}
+					}
+					live.Data[k] = v
 				}
 			}
-			got := make(map[util.ObjectReference]struct{})
-			for _, obj := range cmLst.Items {
-				got[util.ToObjectReference(&obj, testScheme)] = struct{}{}
+			if changed {
+				if err := cl.Update(ctx, live); err != nil {
+					return err
+				}
 			}
-			assert.Equal(t, wants, got, "some object exist and shouldn't or vice versa")
-		})
+			live.DeepCopyInto(applied)
+			return nil
+		},
+	}
+	opts := []Option{WithServerSideApply("test-manager"), WithForceConflicts()}
+	reconcile := func(desired ...*corev1.ConfigMap) bool {
+		var desiredObjs []runtime.Object
+		for _, cm := range desired {
+			desiredObjs = append(desiredObjs, cm.DeepCopy())
+		}
+		changed, err := ReconcileOwnedObjects(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, desiredObjs, &corev1.ConfigMap{}, func(obj, wantedObj runtime.Object) error {
+			t.Error("updateFn must not be called in server-side apply mode")
+			return nil
+		}, opts...)
+		require.NoError(t, err)
+		return changed
+	}
+
+	assert.True(t, reconcile(cmA, cmB), "creating")
+	assert.Equal(t, []string{"test-manager", "test-manager"}, fieldManagers)
+	assert.Equal(t, []bool{true, true}, force)
+
+	live := &corev1.ConfigMap{}
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "default", Name: "cma"}, live))
+	assert.Equal(t, ownerObj.Name, live.Labels[OwnerNameLabel])
+
+	assert.False(t, reconcile(cmA, cmB), "applying unchanged objects")
+
+	cmAPrime := cmA.DeepCopy()
+	cmAPrime.Data["cm"] = "a prime"
+	assert.True(t, reconcile(cmAPrime, cmB), "updating")
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "default", Name: "cma"}, live))
+	assert.Equal(t, "a prime", live.Data["cm"])
+
+	assert.True(t, reconcile(cmAPrime), "pruning")
+	cmLst := &corev1.ConfigMapList{}
+	require.NoError(t, cl.List(ctx, cmLst))
+	if assert.Len(t, cmLst.Items, 1) {
+		assert.Equal(t, "cma", cmLst.Items[0].Name)
+	}
+}
+
+func TestReconcileOwnedObjects_Adoption(t *testing.T) {
+	ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      "ownerObj",
+		Namespace: "default",
+	}}
+	unowned := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"}}
+	ctx := context.Background()
+
+	cl := fakeclient.NewFakeClientWithScheme(testScheme, ownerObj, unowned)
+	_, err := ReconcileOwnedObjects(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, []runtime.Object{unowned.DeepCopy()}, &corev1.ConfigMap{}, nil)
+	require.Error(t, err)
+	assert.True(t, IsOwnershipConflict(err))
+
+	changed, err := ReconcileOwnedObjects(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, []runtime.Object{unowned.DeepCopy()}, &corev1.ConfigMap{}, nil, WithAdoptPolicy(AdoptUnowned))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "default", Name: "cm"}, cm))
+	assert.Equal(t, ownerObj.Name, cm.Labels[OwnerNameLabel])
+}
+
+func TestReconcileOwnedObjects_HashedLongOwnerName(t *testing.T) {
+	ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      strings.Repeat("a", 64),
+		Namespace: "default",
+	}}
+	unrelated := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "default"}}
+	desired := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"}}
+	ctx := context.Background()
+
+	// selectors records the label selectors of list calls.
+	var selectors []labels.Selector
+	cl := &testutil.InterceptingClient{
+		Client: fakeclient.NewFakeClientWithScheme(testScheme, ownerObj, unrelated),
+		ListFunc: func(ctx context.Context, cl client.Client, list runtime.Object, opts ...client.ListOption) error {
+			listOpts := &client.ListOptions{}
+			listOpts.ApplyOptions(opts)
+			selectors = append(selectors, listOpts.LabelSelector)
+			return cl.List(ctx, list, opts...)
+		},
+	}
+	changed, err := ReconcileOwnedObjects(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, []runtime.Object{desired}, &corev1.ConfigMap{}, nil, WithHashedLabels())
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	require.NotEmpty(t, selectors)
+	for _, selector := range selectors {
+		require.NotNil(t, selector)
+		assert.False(t, selector.Empty(), "selector must not match all objects")
+		_, err := labels.Parse(selector.String())
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "default", Name: "unrelated"}, &corev1.ConfigMap{}), "unrelated object must not be deleted")
+}
+
+func TestReconcileOwnedObjects_NativeOwnerReference(t *testing.T) {
+	ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      "ownerObj",
+		Namespace: "default",
+		UID:       "owner-uid",
+	}}
+	desired := []runtime.Object{
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "same-namespace", Namespace: "default"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "other-namespace", Namespace: "other"}},
 	}
+	ctx := context.Background()
+
+	cl := fakeclient.NewFakeClientWithScheme(testScheme, ownerObj)
+	changed, err := ReconcileOwnedObjects(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, desired, &corev1.ConfigMap{}, nil, WithNativeOwnerReference(true, false))
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "default", Name: "same-namespace"}, cm))
+	if assert.Len(t, cm.OwnerReferences, 1) {
+		assert.Equal(t, types.UID("owner-uid"), cm.OwnerReferences[0].UID)
+		assert.True(t, *cm.OwnerReferences[0].Controller)
+		assert.False(t, *cm.OwnerReferences[0].BlockOwnerDeletion)
+	}
+
+	cm = &corev1.ConfigMap{}
+	require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "other", Name: "other-namespace"}, cm))
+	assert.Empty(t, cm.OwnerReferences)
+	assert.Equal(t, ownerObj.Name, cm.Labels[OwnerNameLabel])
+}
+
+func TestReconcileOwnedObjects_DeletionSafeguards(t *testing.T) {
+	ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      "ownerObj",
+		Namespace: "default",
+	}}
+	ctx := context.Background()
+
+	// newClient records the options of delete calls in deleteOpts,
+	// and fails deletions of objects named "changed", as the fake client ignores preconditions.
+	newClient := func(t *testing.T, names ...string) (cl client.Client, deleteOpts map[string]*client.DeleteOptions) {
+		deleteOpts = map[string]*client.DeleteOptions{}
+		cl = &testutil.InterceptingClient{
+			Client: fakeclient.NewFakeClientWithScheme(testScheme, ownerObj),
+			DeleteFunc: func(ctx context.Context, cl client.Client, obj runtime.Object, opts ...client.DeleteOption) error {
+				name := util.ToObjectReference(obj, testScheme).Name
+				deleteOpts[name] = &client.DeleteOptions{}
+				deleteOpts[name].ApplyOptions(opts)
+				if name == "changed" {
+					return apierrors.NewConflict(schema.GroupResource{Resource: "configmaps"}, name, fmt.Errorf("precondition failed"))
+				}
+				return cl.Delete(ctx, obj, opts...)
+			},
+		}
+		for _, name := range names {
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name + "-uid")}}
+			if name == "kept" {
+				cm.Annotations = map[string]string{KeepAnnotation: "true"}
+			}
+			_, err := SetOwnerReference(ownerObj, cm, testScheme)
+			require.NoError(t, err)
+			require.NoError(t, cl.Create(ctx, cm))
+		}
+		return cl, deleteOpts
+	}
+
+	t.Run("keep annotation, preconditions and propagation policy", func(t *testing.T) {
+		cl, deleteOpts := newClient(t, "a", "kept", "changed")
+
+		result, err := ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, nil,
+			[]runtime.Object{&corev1.ConfigMap{}}, nil, WithPropagationPolicy(metav1.DeletePropagationForeground))
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.For(corev1.SchemeGroupVersion.WithKind("ConfigMap")).Deleted)
+
+		var skipped []string
+		for _, s := range result.Skipped() {
+			skipped = append(skipped, s.Object.Name)
+		}
+		assert.ElementsMatch(t, []string{"kept", "changed"}, skipped)
+		assert.NotContains(t, deleteOpts, "kept")
+
+		require.NotNil(t, deleteOpts["a"])
+		require.NotNil(t, deleteOpts["a"].Preconditions)
+		assert.Equal(t, types.UID("a-uid"), *deleteOpts["a"].Preconditions.UID)
+		assert.NotEmpty(t, *deleteOpts["a"].Preconditions.ResourceVersion)
+		assert.Equal(t, metav1.DeletePropagationForeground, *deleteOpts["a"].PropagationPolicy)
+	})
+
+	t.Run("max deletions", func(t *testing.T) {
+		cl, deleteOpts := newClient(t, "a", "b", "kept")
+
+		_, err := ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, nil,
+			[]runtime.Object{&corev1.ConfigMap{}}, nil, WithMaxDeletions(1))
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrMaxDeletionsExceeded))
+		assert.Empty(t, deleteOpts, "nothing must be deleted")
+
+		// kept objects do not count.
+		result, err := ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, nil,
+			[]runtime.Object{&corev1.ConfigMap{}}, nil, WithMaxDeletions(2))
+		require.NoError(t, err)
+		assert.Equal(t, 2, result.For(corev1.SchemeGroupVersion.WithKind("ConfigMap")).Deleted)
+	})
+}
+
+func TestReconcileOwnedObjects_Results(t *testing.T) {
+	ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      "ownerObj",
+		Namespace: "default",
+	}}
+	otherOwner := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      "other",
+		Namespace: "default",
+	}}
+	ctx := context.Background()
+
+	cl := fakeclient.NewFakeClientWithScheme(testScheme, ownerObj)
+	for name, owner := range map[string]runtime.Object{"unchanged": ownerObj, "obsolete": ownerObj, "kept": ownerObj, "conflict": otherOwner} {
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+		if name == "kept" {
+			cm.Annotations = map[string]string{KeepAnnotation: "true"}
+		}
+		_, err := SetOwnerReference(owner, cm, testScheme)
+		require.NoError(t, err)
+		require.NoError(t, cl.Create(ctx, cm))
+	}
+
+	desired := []runtime.Object{
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unchanged", Namespace: "default"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "conflict", Namespace: "default"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "new", Namespace: "default"}},
+	}
+	recorder := record.NewFakeRecorder(10)
+	result, err := ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, desired,
+		[]runtime.Object{&corev1.ConfigMap{}}, nil, WithEventRecorder(recorder))
+	require.Error(t, err)
+	assert.True(t, IsOwnershipConflict(err), "aggregated errors must be inspectable")
+
+	operations := map[string]Operation{}
+	for _, obj := range result.Objects {
+		operations[obj.Object.Name] = obj.Operation
+	}
+	assert.Equal(t, map[string]Operation{
+		"kept":      OperationSkipped,
+		"obsolete":  OperationDeleted,
+		"unchanged": OperationUnchanged,
+		"conflict":  OperationUpdated,
+		"new":       OperationCreated,
+	}, operations)
+
+	failed := result.Failed()
+	require.Len(t, failed, 1)
+	assert.Equal(t, "conflict", failed[0].Object.Name)
+	typeResult := result.For(corev1.SchemeGroupVersion.WithKind("ConfigMap"))
+	assert.Equal(t, &TypeResult{
+		GroupVersionKind: corev1.SchemeGroupVersion.WithKind("ConfigMap"),
+		Created:          1,
+		Deleted:          1,
+		Skipped:          1,
+		Failed:           1,
+	}, typeResult)
+
+	close(recorder.Events)
+	var events []string
+	for event := range recorder.Events {
+		events = append(events, event)
+	}
+	assert.Equal(t, []string{
+		"Normal OwnedObjectSkipped ConfigMap./default:kept skipped: kept by annotation owner.kubermatic.io/keep",
+		"Normal OwnedObjectDeleted ConfigMap./default:obsolete deleted",
+		"Warning OwnedObjectFailed ConfigMap./default:conflict updated failed: " + failed[0].Error.Error(),
+		"Normal OwnedObjectCreated ConfigMap./default:new created",
+	}, events)
+}
+
+func TestReconcileOwnedObjects_SkipConflicts(t *testing.T) {
+	ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "default"}}
+	otherOwner := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}}
+	ctx := context.Background()
+
+	cl := fakeclient.NewFakeClientWithScheme(testScheme, ownerObj)
+	owned := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "owned-by-other", Namespace: "default"}}
+	_, err := SetOwnerReference(otherOwner, owned, testScheme)
+	require.NoError(t, err)
+	require.NoError(t, cl.Create(ctx, owned))
+	require.NoError(t, cl.Create(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unowned", Namespace: "default"}}))
+
+	desired := []runtime.Object{
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "owned-by-other", Namespace: "default"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unowned", Namespace: "default"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "new", Namespace: "default"}},
+	}
+	objectTypes := []runtime.Object{&corev1.ConfigMap{}}
+
+	plan, err := PlanOwnedObjects(ctx, cl, testScheme, ownerObj, desired, objectTypes, nil, WithSkipConflicts())
+	require.NoError(t, err)
+	assert.Equal(t, []PlannedChange{{Action: ActionCreate, Object: util.ToObjectReference(desired[2], testScheme)}}, plan.Changes)
+
+	result, err := ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, desired, objectTypes, nil, WithSkipConflicts())
+	require.NoError(t, err)
+	assert.Empty(t, result.Failed())
+
+	conflicts := result.Conflicts()
+	require.Len(t, conflicts, 2)
+	assert.Equal(t, "owned-by-other", conflicts[0].Object.Name)
+	assert.Equal(t, util.ToObjectReference(otherOwner, testScheme), conflicts[0].Existing)
+	assert.Equal(t, util.ToObjectReference(ownerObj, testScheme), conflicts[0].Requested)
+	assert.Equal(t, "unowned", conflicts[1].Object.Name)
+	assert.Equal(t, util.ObjectReference{}, conflicts[1].Existing)
+	assert.Len(t, result.Skipped(), 2)
+	assert.Equal(t, 1, result.For(corev1.SchemeGroupVersion.WithKind("ConfigMap")).Created)
+
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, cl.Get(ctx, client.ObjectKey{Name: "owned-by-other", Namespace: "default"}, cm))
+	ref, _, err := GetOwnerReference(cm)
+	require.NoError(t, err)
+	assert.Equal(t, util.ToObjectReference(otherOwner, testScheme), ref, "conflicting objects are left alone")
+}
+
+func TestReconcileOwnedObjects_SkipConflictsWithOwnerUIDs(t *testing.T) {
+	ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "default", UID: "owner-uid"}}
+	otherOwner := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", UID: "other-uid"}}
+	ctx := context.Background()
+
+	cl := fakeclient.NewFakeClientWithScheme(testScheme, ownerObj)
+	owned := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "owned-by-other", Namespace: "default"}}
+	_, err := SetOwnerReference(otherOwner, owned, testScheme)
+	require.NoError(t, err)
+	require.NoError(t, cl.Create(ctx, owned))
+
+	desired := []runtime.Object{
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "owned-by-other", Namespace: "default"}},
+	}
+	objectTypes := []runtime.Object{&corev1.ConfigMap{}}
+
+	// the UID recorded by the other owner must not be mistaken for a recreated owner.
+	result, err := ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, desired, objectTypes, nil, WithSkipConflicts())
+	require.NoError(t, err)
+	assert.Empty(t, result.Failed())
+	conflicts := result.Conflicts()
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, util.ToObjectReference(otherOwner, testScheme), conflicts[0].Existing)
+
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, cl.Get(ctx, client.ObjectKey{Name: "owned-by-other", Namespace: "default"}, cm))
+	uid, _ := GetOwnerUID(cm)
+	assert.Equal(t, types.UID("other-uid"), uid, "conflicting objects are left alone")
+}
+
+func TestReconcileOwnedObjects_Unstructured(t *testing.T) {
+	ownerObj := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "default"}}
+	ctx := context.Background()
+
+	// Widgets are not known to the scheme, like custom resources of rendered third-party manifests.
+	widgetGVK := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+	newWidget := func(name string, size int64) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(widgetGVK)
+		u.SetName(name)
+		u.SetNamespace("default")
+		require.NoError(t, unstructured.SetNestedField(u.Object, size, "spec", "size"))
+		return u
+	}
+	widgetType := &unstructured.Unstructured{}
+	widgetType.SetGroupVersionKind(widgetGVK)
+	configMapType := &unstructured.Unstructured{}
+	configMapType.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("ConfigMap"))
+	objectTypes := []runtime.Object{widgetType, configMapType}
+
+	// the object tracker of the fake client cannot list unknown types,
+	// so the fake client gets a scheme with Widgets, while the code under test does not know them.
+	clientScheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(clientScheme))
+	clientScheme.AddKnownTypeWithName(widgetGVK, &unstructured.Unstructured{})
+	clientScheme.AddKnownTypeWithName(widgetGVK.GroupVersion().WithKind("WidgetList"), &unstructured.UnstructuredList{})
+	cl := fakeclient.NewFakeClientWithScheme(clientScheme, ownerObj)
+
+	obsolete := newWidget("obsolete", 1)
+	_, err := SetOwnerReference(ownerObj, obsolete, testScheme)
+	require.NoError(t, err)
+	require.NoError(t, cl.Create(ctx, obsolete))
+
+	desired := []runtime.Object{
+		newWidget("small", 1),
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "typed", Namespace: "default"}},
+	}
+	result, err := ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, desired, objectTypes, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.For(widgetGVK).Created)
+	assert.Equal(t, 1, result.For(widgetGVK).Deleted)
+	assert.Equal(t, 1, result.For(corev1.SchemeGroupVersion.WithKind("ConfigMap")).Created)
+
+	// desired objects are updated and unchanged objects are left alone, whether typed or not.
+	desired = []runtime.Object{
+		newWidget("small", 2),
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "typed", Namespace: "default"}},
+	}
+	result, err = ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, desired, objectTypes,
+		func(actual, desired runtime.Object) error {
+			if u, ok := actual.(*unstructured.Unstructured); ok {
+				u.Object["spec"] = desired.(*unstructured.Unstructured).Object["spec"]
+			}
+			return nil
+		})
+	require.NoError(t, err)
+	assert.Equal(t, &TypeResult{GroupVersionKind: widgetGVK, Updated: 1}, result.For(widgetGVK))
+	assert.False(t, result.For(corev1.SchemeGroupVersion.WithKind("ConfigMap")).Changed())
+
+	widgets, err := util.ListObjects(ctx, cl, testScheme, []runtime.Object{widgetType}, OwnedBy(ownerObj, testScheme))
+	require.NoError(t, err)
+	require.Len(t, widgets, 1)
+	size, _, err := unstructured.NestedInt64(widgets[0].(*unstructured.Unstructured).Object, "spec", "size")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), size)
+
+	cleanedUp, err := util.DeleteObjects(ctx, cl, testScheme, objectTypes, OwnedBy(ownerObj, testScheme))
+	require.NoError(t, err)
+	assert.False(t, cleanedUp)
+	cleanedUp, err = util.DeleteObjects(ctx, cl, testScheme, objectTypes, OwnedBy(ownerObj, testScheme))
+	require.NoError(t, err)
+	assert.True(t, cleanedUp)
 }
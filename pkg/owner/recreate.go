@@ -0,0 +1,136 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package owner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"k8c.io/utils/pkg/util"
+)
+
+// NotFoundWaiter waits until an object is gone, e.g. *util.ClientWatcher.
+type NotFoundWaiter interface {
+	WaitUntilNotFound(ctx context.Context, obj runtime.Object, options ...util.ClientWatcherOption) error
+}
+
+// recreates reports whether objects of the type of obj are recreated on immutable field changes.
+func (o *options) recreates(obj runtime.Object, scheme *runtime.Scheme) bool {
+	gvk, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		return false
+	}
+	for _, objType := range o.recreateTypes {
+		typeGVK, err := apiutil.GVKForObject(objType, scheme)
+		if err == nil && typeGVK.GroupKind() == gvk.GroupKind() {
+			return true
+		}
+	}
+	return false
+}
+
+// isImmutableFieldError reports whether err rejects an update, because it changes immutable fields.
+func isImmutableFieldError(err error) bool {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) || status.Status().Reason != metav1.StatusReasonInvalid {
+		return false
+	}
+	details := status.Status().Details
+	if details == nil || len(details.Causes) == 0 {
+		return strings.Contains(status.Status().Message, "immutable")
+	}
+	for _, cause := range details.Causes {
+		// e.g. "field is immutable" for Job templates, Service clusterIPs and Secret types,
+		// and "updates to statefulset spec for fields other than ... are forbidden" for StatefulSets.
+		if strings.Contains(cause.Message, "immutable") ||
+			cause.Type == metav1.CauseType(field.ErrorTypeForbidden) && strings.Contains(cause.Message, "updates to") {
+			return true
+		}
+	}
+	return false
+}
+
+// applyOrRecreate applies the desired object, and recreates it,
+// if the update is rejected because of immutable fields and its type is recreated.
+func applyOrRecreate(ctx context.Context, cl client.Client, scheme *runtime.Scheme, ownerObj, obj runtime.Object, updateFn updateFunc, opts []Option, o *options) (Operation, Diff, error) {
+	// applyObject overrides obj with the live object.
+	desired := obj.DeepCopyObject()
+	op, diff, err := applyObject(ctx, cl, scheme, ownerObj, obj, updateFn, opts, o, false)
+	if err == nil || op != controllerutil.OperationResultUpdated || !isImmutableFieldError(err) || !o.recreates(obj, scheme) {
+		return operationFor(op), diff, err
+	}
+	if err := recreateObject(ctx, cl, scheme, ownerObj, desired, updateFn, opts, o); err != nil {
+		return OperationRecreated, nil, err
+	}
+	return OperationRecreated, nil, nil
+}
+
+// recreateObject deletes the live object of desired, and creates desired again.
+func recreateObject(ctx context.Context, cl client.Client, scheme *runtime.Scheme, ownerObj, desired runtime.Object, updateFn updateFunc, opts []Option, o *options) error {
+	key, err := client.ObjectKeyFromObject(desired)
+	if err != nil {
+		return err
+	}
+	live, err := util.NewObject(desired, scheme)
+	if err != nil {
+		return err
+	}
+	if err := cl.Get(ctx, key, live); err != nil {
+		return fmt.Errorf("getting %s: %w", util.MustLogLine(desired, scheme), err)
+	}
+	// the preconditions make sure only the object rejecting the update is deleted.
+	deleteOpts, err := deleteOptions(live, o)
+	if err != nil {
+		return err
+	}
+	if err := cl.Delete(ctx, live, deleteOpts...); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("deleting %s: %w", util.MustLogLine(desired, scheme), err)
+	}
+	if o.recreateWaiter != nil {
+		if err := o.recreateWaiter.WaitUntilNotFound(ctx, live, o.recreateWaitOptions...); err != nil {
+			return fmt.Errorf("waiting for deletion: %w", err)
+		}
+	}
+
+	obj := desired.DeepCopyObject()
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	accessor.SetResourceVersion("")
+	accessor.SetUID("")
+	op, _, err := applyObject(ctx, cl, scheme, ownerObj, obj, updateFn, opts, o, false)
+	if err != nil {
+		return err
+	}
+	if op != controllerutil.OperationResultCreated {
+		// e.g. deletion is blocked by finalizers, the object is recreated in a later run.
+		return fmt.Errorf("%s still exists after deletion", util.MustLogLine(desired, scheme))
+	}
+	return nil
+}
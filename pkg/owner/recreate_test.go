@@ -0,0 +1,136 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package owner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"k8c.io/utils/pkg/testutil"
+	"k8c.io/utils/pkg/util"
+)
+
+// recordingWaiter records the objects waited for.
+type recordingWaiter struct {
+	waited []string
+}
+
+func (w *recordingWaiter) WaitUntilNotFound(_ context.Context, obj runtime.Object, _ ...util.ClientWatcherOption) error {
+	w.waited = append(w.waited, obj.(*corev1.Secret).Name)
+	return nil
+}
+
+func TestReconcileOwnedObjects_Recreate(t *testing.T) {
+	ownerObj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "default"}}
+	ctx := context.Background()
+	secretGVK := corev1.SchemeGroupVersion.WithKind("Secret")
+
+	newClient := func(t *testing.T) client.Client {
+		cl := fakeclient.NewFakeClientWithScheme(testScheme, ownerObj)
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "default", UID: "old-uid"}, Type: corev1.SecretTypeOpaque}
+		_, err := SetOwnerReference(ownerObj, secret, testScheme)
+		require.NoError(t, err)
+		require.NoError(t, cl.Create(ctx, secret))
+		return &testutil.InterceptingClient{
+			Client: cl,
+			// reject updates changing the type of Secrets, like the API server.
+			UpdateFunc: func(ctx context.Context, cl client.Client, obj runtime.Object, opts ...client.UpdateOption) error {
+				if secret, ok := obj.(*corev1.Secret); ok {
+					live := &corev1.Secret{}
+					if err := cl.Get(ctx, client.ObjectKey{Name: secret.Name, Namespace: secret.Namespace}, live); err != nil {
+						return err
+					}
+					if errs := apivalidation.ValidateImmutableField(secret.Type, live.Type, field.NewPath("type")); len(errs) > 0 {
+						return apierrors.NewInvalid(corev1.SchemeGroupVersion.WithKind("Secret").GroupKind(), secret.Name, errs)
+					}
+				}
+				return cl.Update(ctx, obj, opts...)
+			},
+		}
+	}
+	desired := []runtime.Object{
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "default"},
+			Type:       corev1.SecretTypeTLS,
+			Data:       map[string][]byte{corev1.TLSCertKey: []byte("cert"), corev1.TLSPrivateKeyKey: []byte("key")},
+		},
+	}
+	updateFn := func(actual, desired runtime.Object) error {
+		actual.(*corev1.Secret).Type = desired.(*corev1.Secret).Type
+		actual.(*corev1.Secret).Data = desired.(*corev1.Secret).Data
+		return nil
+	}
+	objectTypes := []runtime.Object{&corev1.Secret{}}
+
+	t.Run("without policy", func(t *testing.T) {
+		cl := newClient(t)
+		result, err := ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, desired, objectTypes, updateFn)
+		require.Error(t, err)
+		assert.True(t, isImmutableFieldError(err))
+		assert.Equal(t, 1, result.For(secretGVK).Failed)
+	})
+
+	t.Run("other types", func(t *testing.T) {
+		cl := newClient(t)
+		_, err := ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, desired, objectTypes, updateFn,
+			WithRecreateOnImmutableChange(&corev1.ConfigMap{}))
+		assert.Error(t, err)
+	})
+
+	t.Run("plan", func(t *testing.T) {
+		cl := newClient(t)
+		plan, err := PlanOwnedObjects(ctx, cl, testScheme, ownerObj, desired, objectTypes, updateFn,
+			WithRecreateOnImmutableChange(&corev1.Secret{}))
+		require.NoError(t, err)
+		assert.Equal(t, []PlannedChange{{Action: ActionRecreate, Object: util.ToObjectReference(desired[0], testScheme)}}, plan.Changes)
+	})
+
+	t.Run("recreate", func(t *testing.T) {
+		cl := newClient(t)
+		waiter := &recordingWaiter{}
+		result, err := ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, desired, objectTypes, updateFn,
+			WithRecreateOnImmutableChange(&corev1.Secret{}), WithRecreateWaiter(waiter))
+		require.NoError(t, err)
+		assert.Equal(t, &TypeResult{GroupVersionKind: secretGVK, Recreated: 1}, result.For(secretGVK))
+		assert.Equal(t, OperationRecreated, result.Objects[0].Operation)
+		assert.True(t, result.Changed())
+		assert.Equal(t, []string{"secret"}, waiter.waited)
+
+		secret := &corev1.Secret{}
+		require.NoError(t, cl.Get(ctx, client.ObjectKey{Name: "secret", Namespace: "default"}, secret))
+		assert.Equal(t, corev1.SecretTypeTLS, secret.Type)
+		assert.NotEqual(t, "old-uid", string(secret.UID))
+		assert.True(t, IsOwned(secret))
+
+		// the recreated object is up to date.
+		result, err = ReconcileOwnedObjectsOfTypes(ctx, cl, testutil.NewLogger(t), testScheme, ownerObj, desired, objectTypes, updateFn,
+			WithRecreateOnImmutableChange(&corev1.Secret{}))
+		require.NoError(t, err)
+		assert.False(t, result.Changed())
+	})
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package owner
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	"k8c.io/utils/pkg/util"
+)
+
+// Operation is what happened to a single object.
+type Operation string
+
+// Operations on owned objects.
+const (
+	OperationCreated   Operation = "created"
+	OperationUpdated   Operation = "updated"
+	OperationDeleted   Operation = "deleted"
+	OperationUnchanged Operation = "unchanged"
+	// OperationRecreated is used for objects, which were deleted and created again, see WithRecreateOnImmutableChange.
+	OperationRecreated Operation = "recreated"
+	// OperationSkipped is used for objects, which were left alone, e.g. protected from deletion.
+	OperationSkipped Operation = "skipped"
+)
+
+// ReconcileResult is the outcome of reconciling owned objects.
+type ReconcileResult struct {
+	// Types holds the results per object type, in apply order.
+	Types []*TypeResult
+	// Objects holds the results per object, in the order they were processed.
+	Objects []ObjectResult
+}
+
+// ObjectResult is the outcome of reconciling a single object.
+type ObjectResult struct {
+	Object util.ObjectReference
+	// Operation is the operation done or attempted.
+	Operation Operation
+	// Reason explains skipped operations.
+	Reason string
+	// Error is set, if the operation failed.
+	Error error
+	// Conflict is set for objects skipped due to an ownership conflict, see WithSkipConflicts.
+	Conflict *OwnershipConflictError
+	// WriteSkipped is set for unchanged objects, which were not written, because they are up to date
+	// with their desired state hash, see WithDesiredStateHash.
+	WriteSkipped bool
+}
+
+// TypeResult is the outcome of reconciling owned objects of a single type.
+type TypeResult struct {
+	GroupVersionKind schema.GroupVersionKind
+	Created          int
+	Updated          int
+	Deleted          int
+	Recreated        int
+	Skipped          int
+	Failed           int
+	// WriteSkipped counts unchanged objects, which were not written, see WithDesiredStateHash.
+	WriteSkipped int
+}
+
+// Changed reports whether any object of this type was changed.
+func (r *TypeResult) Changed() bool {
+	return r.Created+r.Updated+r.Deleted+r.Recreated > 0
+}
+
+// Changed reports whether any object was changed.
+func (r *ReconcileResult) Changed() bool {
+	if r == nil {
+		return false
+	}
+	for _, t := range r.Types {
+		if t.Changed() {
+			return true
+		}
+	}
+	return false
+}
+
+// Skipped returns the results of all skipped objects.
+func (r *ReconcileResult) Skipped() []ObjectResult {
+	var skipped []ObjectResult
+	for _, obj := range r.Objects {
+		if obj.Operation == OperationSkipped {
+			skipped = append(skipped, obj)
+		}
+	}
+	return skipped
+}
+
+// Conflicts returns the ownership conflicts of all objects skipped with WithSkipConflicts,
+// e.g. to surface them in a status condition of the owner.
+func (r *ReconcileResult) Conflicts() []*OwnershipConflictError {
+	var conflicts []*OwnershipConflictError
+	for _, obj := range r.Objects {
+		if obj.Conflict != nil {
+			conflicts = append(conflicts, obj.Conflict)
+		}
+	}
+	return conflicts
+}
+
+// Failed returns the results of all objects, which failed to reconcile.
+func (r *ReconcileResult) Failed() []ObjectResult {
+	var failed []ObjectResult
+	for _, obj := range r.Objects {
+		if obj.Error != nil {
+			failed = append(failed, obj)
+		}
+	}
+	return failed
+}
+
+// add records the result of a single object.
+func (r *ReconcileResult) add(obj runtime.Object, scheme *runtime.Scheme, objectResult ObjectResult) error {
+	t, err := r.forObject(obj, scheme)
+	if err != nil {
+		return err
+	}
+	switch {
+	case objectResult.Error != nil:
+		t.Failed++
+	case objectResult.Operation == OperationCreated:
+		t.Created++
+	case objectResult.Operation == OperationUpdated:
+		t.Updated++
+	case objectResult.Operation == OperationDeleted:
+		t.Deleted++
+	case objectResult.Operation == OperationRecreated:
+		t.Recreated++
+	case objectResult.Operation == OperationSkipped:
+		t.Skipped++
+	case objectResult.WriteSkipped:
+		t.WriteSkipped++
+	}
+	r.Objects = append(r.Objects, objectResult)
+	return nil
+}
+
+// For returns the result for the given type, or nil if the type was not reconciled.
+func (r *ReconcileResult) For(gvk schema.GroupVersionKind) *TypeResult {
+	for _, t := range r.Types {
+		if t.GroupVersionKind == gvk {
+			return t
+		}
+	}
+	return nil
+}
+
+// forObject returns the result for the type of obj, adding it if necessary.
+func (r *ReconcileResult) forObject(obj runtime.Object, scheme *runtime.Scheme) (*TypeResult, error) {
+	gvk, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get GVK for %T: %w", obj, err)
+	}
+	if t := r.For(gvk); t != nil {
+		return t, nil
+	}
+
+	t := &TypeResult{GroupVersionKind: gvk}
+	// keep types in apply order.
+	i := 0
+	for i < len(r.Types) && applyRank(r.Types[i].GroupVersionKind.Kind) <= applyRank(gvk.Kind) {
+		i++
+	}
+	r.Types = append(r.Types, nil)
+	copy(r.Types[i+1:], r.Types[i:])
+	r.Types[i] = t
+	return t, nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package owner
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"k8c.io/utils/pkg/util"
+)
+
+// TransferOwnership moves the given objects from one owner to another, without recreating them.
+//
+// Objects must be owned by from, objects owned by another owner fail with an OwnershipConflictError.
+// Objects already owned by to are skipped, so an interrupted transfer can be retried.
+// The owner labels are patched with a resourceVersion precondition,
+// so objects changed since they were read fail instead of being transferred blindly.
+// With WithNativeOwnerReference, the metav1.OwnerReference is moved to the new owner as well.
+// The objects are updated in place.
+func TransferOwnership(ctx context.Context, cl client.Client, scheme *runtime.Scheme, from, to runtime.Object, objs []runtime.Object, opts ...Option) error {
+	o := buildOptions(opts)
+	fromRef, err := ownerReference(from, scheme, o)
+	if err != nil {
+		return err
+	}
+	toRef, err := ownerReference(to, scheme, o)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objs {
+		key := util.ToObjectReference(obj, scheme)
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		current, owned, err := referenceFromObject(accessor)
+		if err != nil {
+			return fmt.Errorf("reading owner of %s: %w", key, err)
+		}
+		if owned && current == toRef {
+			continue
+		}
+		if !owned || current != fromRef {
+			return &OwnershipConflictError{
+				Object:    key,
+				Existing:  current,
+				Requested: toRef,
+			}
+		}
+
+		transferred := obj.DeepCopyObject()
+		RemoveOwnerReference(nil, transferred, opts...)
+		if _, err := SetOwnerReference(to, transferred, scheme, opts...); err != nil {
+			return err
+		}
+		patch, err := ownerMetadataPatch(accessor.GetResourceVersion(), transferred)
+		if err != nil {
+			return fmt.Errorf("transferring %s: %w", key, err)
+		}
+		if err := cl.Patch(ctx, obj, patch); err != nil {
+			return fmt.Errorf("transferring %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// ownerMetadataPatch returns a JSON patch replacing labels, annotations and ownerReferences with the ones of obj,
+// which only applies to the given resourceVersion.
+func ownerMetadataPatch(resourceVersion string, obj runtime.Object) (client.Patch, error) {
+	if resourceVersion == "" {
+		return nil, fmt.Errorf("object has no resourceVersion")
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	labels, annotations := accessor.GetLabels(), accessor.GetAnnotations()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	ownerReferences := accessor.GetOwnerReferences()
+	if ownerReferences == nil {
+		ownerReferences = []metav1.OwnerReference{}
+	}
+	return util.NewJSONPatch(
+		util.JSONPatchOperation{Op: "test", Path: "/metadata/resourceVersion", Value: resourceVersion},
+		util.JSONPatchOperation{Op: "add", Path: "/metadata/labels", Value: labels},
+		util.JSONPatchOperation{Op: "add", Path: "/metadata/annotations", Value: annotations},
+		util.JSONPatchOperation{Op: "add", Path: "/metadata/ownerReferences", Value: ownerReferences},
+	)
+}
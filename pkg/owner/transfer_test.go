@@ -0,0 +1,134 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package owner
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"k8c.io/utils/pkg/util"
+)
+
+func TestTransferOwnership(t *testing.T) {
+	from := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "from", Namespace: "default"}}
+	to := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "to", Namespace: "default"}}
+	other := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}}
+	ctx := context.Background()
+
+	// objects are created through the client, so they get a resourceVersion.
+	newClient := func(t *testing.T, owner runtime.Object, names ...string) client.Client {
+		t.Helper()
+		cl := fakeclient.NewFakeClientWithScheme(testScheme)
+		for _, name := range names {
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+			_, err := SetOwnerReference(owner, cm, testScheme)
+			require.NoError(t, err)
+			require.NoError(t, cl.Create(ctx, cm))
+		}
+		return cl
+	}
+
+	t.Run("transfer", func(t *testing.T) {
+		cl := newClient(t, from, "a", "b")
+		var objs []runtime.Object
+		for _, name := range []string{"a", "b"} {
+			cm := &corev1.ConfigMap{}
+			require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, cm))
+			objs = append(objs, cm)
+		}
+
+		require.NoError(t, TransferOwnership(ctx, cl, testScheme, from, to, objs))
+		for _, name := range []string{"a", "b"} {
+			cm := &corev1.ConfigMap{}
+			require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, cm))
+			ref, owned, err := GetOwnerReference(cm)
+			require.NoError(t, err)
+			assert.True(t, owned)
+			assert.Equal(t, util.ToObjectReference(to, testScheme), ref)
+		}
+
+		// retrying an interrupted transfer is fine.
+		require.NoError(t, TransferOwnership(ctx, cl, testScheme, from, to, objs))
+	})
+
+	t.Run("owned by another owner", func(t *testing.T) {
+		cl := newClient(t, other, "a")
+		cm := &corev1.ConfigMap{}
+		require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "default", Name: "a"}, cm))
+
+		err := TransferOwnership(ctx, cl, testScheme, from, to, []runtime.Object{cm})
+		var conflict *OwnershipConflictError
+		if assert.True(t, errors.As(err, &conflict)) {
+			assert.Equal(t, util.ToObjectReference(other, testScheme), conflict.Existing)
+			assert.Equal(t, util.ToObjectReference(to, testScheme), conflict.Requested)
+			assert.Equal(t, "a", conflict.Object.Name)
+		}
+	})
+
+	t.Run("changed since read", func(t *testing.T) {
+		cl := newClient(t, from, "a")
+		stale := &corev1.ConfigMap{}
+		require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "default", Name: "a"}, stale))
+
+		// someone else moved the object meanwhile.
+		current := stale.DeepCopy()
+		RemoveOwnerReference(nil, current)
+		_, err := SetOwnerReference(other, current, testScheme)
+		require.NoError(t, err)
+		require.NoError(t, cl.Update(ctx, current))
+
+		require.Error(t, TransferOwnership(ctx, cl, testScheme, from, to, []runtime.Object{stale}))
+		require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "default", Name: "a"}, current))
+		ref, _, err := GetOwnerReference(current)
+		require.NoError(t, err)
+		assert.Equal(t, util.ToObjectReference(other, testScheme), ref)
+	})
+
+	t.Run("native owner reference", func(t *testing.T) {
+		from := from.DeepCopy()
+		from.UID = "from-uid"
+		to := to.DeepCopy()
+		to.UID = "to-uid"
+		opts := []Option{WithNativeOwnerReference(true, false)}
+
+		cl := fakeclient.NewFakeClientWithScheme(testScheme)
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default"}}
+		_, err := SetOwnerReference(from, cm, testScheme, opts...)
+		require.NoError(t, err)
+		require.NoError(t, cl.Create(ctx, cm))
+
+		require.NoError(t, TransferOwnership(ctx, cl, testScheme, from, to, []runtime.Object{cm}, opts...))
+		cm = &corev1.ConfigMap{}
+		require.NoError(t, cl.Get(ctx, types.NamespacedName{Namespace: "default", Name: "a"}, cm))
+		if assert.Len(t, cm.OwnerReferences, 1) {
+			assert.Equal(t, "to", cm.OwnerReferences[0].Name)
+			assert.Equal(t, types.UID("to-uid"), cm.OwnerReferences[0].UID)
+		}
+		uid, _ := GetOwnerUID(cm)
+		assert.Equal(t, types.UID("to-uid"), uid)
+	})
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2020 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ownermigrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"k8c.io/utils/pkg/owner"
+	"k8c.io/utils/pkg/util"
+)
+
+type Flags struct {
+	*util.KubeFlags
+	From         string
+	To           string
+	Resources    []string
+	DryRun       bool
+	Keep         bool
+	HashedLabels bool
+}
+
+func NewFlags() *Flags {
+	return &Flags{KubeFlags: util.NewKubeFlags()}
+}
+
+func (f *Flags) NewCommand(log logr.Logger, use string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: use,
+		Long: strings.TrimSpace(`
+Converts the owners of objects between owner.kubermatic.io/* labels, the kubermatic.io/owner annotation
+and native ownerReferences.
+
+Only objects of the types given with --resource are migrated, e.g. --resource configmaps --resource deployments.apps.
+Owners are removed from the source encoding, unless --keep is set.
+Migrating again is a no-op, and all migrated objects are verified afterwards.
+Objects are skipped, if their owners cannot be expressed in the target encoding.
+`),
+		Short: "migrate owners between encodings",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			from, err := ParseEncoding(f.From)
+			if err != nil {
+				return fmt.Errorf("--from: %w", err)
+			}
+			to, err := ParseEncoding(f.To)
+			if err != nil {
+				return fmt.Errorf("--to: %w", err)
+			}
+			if len(f.Resources) == 0 {
+				return fmt.Errorf("at least one --resource is required")
+			}
+			if err := f.Validate(); err != nil {
+				return err
+			}
+
+			clients, err := f.NewClients()
+			if err != nil {
+				return err
+			}
+
+			ctx, closeCtx := context.WithCancel(context.Background())
+			defer closeCtx()
+
+			objTypes, err := resourceTypes(clients.Mapper, f.Resources)
+			if err != nil {
+				return err
+			}
+
+			listOptions, err := f.ListOptions()
+			if err != nil {
+				return err
+			}
+
+			migrator := &Migrator{
+				Client: clients.Client,
+				Mapper: clients.Mapper,
+				Scheme: clients.Scheme,
+				Log:    log,
+				From:   from,
+				To:     to,
+				Keep:   f.Keep,
+				DryRun: f.DryRun,
+			}
+			if f.HashedLabels {
+				migrator.OwnerOptions = append(migrator.OwnerOptions, owner.WithHashedLabels())
+			}
+			changes, err := migrator.Migrate(ctx, objTypes, listOptions...)
+			if printErr := printChanges(cmd.OutOrStdout(), f.Output, changes); printErr != nil {
+				return printErr
+			}
+			if err != nil {
+				return err
+			}
+			if f.DryRun {
+				return nil
+			}
+			return migrator.Verify(ctx, changes)
+		},
+	}
+
+	f.AddFlags(cmd, "migrate objects in all namespaces")
+	cmd.Flags().StringVar(&f.From, "from", f.From, "encoding to migrate from, one of: labels, annotation, ownerReferences")
+	cmd.Flags().StringVar(&f.To, "to", f.To, "encoding to migrate to, one of: labels, annotation, ownerReferences")
+	cmd.Flags().StringArrayVar(&f.Resources, "resource", f.Resources, "resource to migrate, e.g. configmaps or deployments.apps, can be repeated")
+	cmd.Flags().BoolVar(&f.DryRun, "dry-run", f.DryRun, "only print the changes, and send updates as dry-run requests")
+	cmd.Flags().BoolVar(&f.Keep, "keep", f.Keep, "keep owners in the --from encoding")
+	cmd.Flags().BoolVar(&f.HashedLabels, "hashed-labels", f.HashedLabels, "write hashed owner labels, see owner.WithHashedLabels")
+	return cmd
+}
+
+// resourceTypes resolves resource arguments like "deployments.apps" to type hints for listing.
+func resourceTypes(mapper meta.RESTMapper, resources []string) ([]runtime.Object, error) {
+	var objTypes []runtime.Object
+	for _, resource := range resources {
+		gvk, err := mapper.KindFor(schema.ParseGroupResource(resource).WithVersion(""))
+		if err != nil {
+			return nil, fmt.Errorf("resolving resource %q: %w", resource, err)
+		}
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gvk)
+		objTypes = append(objTypes, obj)
+	}
+	return objTypes, nil
+}
+
+func printChanges(w io.Writer, output string, changes []Change) error {
+	var rows [][]string
+	for _, change := range changes {
+		owners := make([]string, 0, len(change.Owners))
+		for _, ref := range change.Owners {
+			owners = append(owners, ref.String())
+		}
+		rows = append(rows, []string{
+			change.Object.Kind + "." + change.Object.Group,
+			change.Object.Namespace,
+			change.Object.Name,
+			strings.Join(owners, ","),
+			change.Skipped,
+		})
+	}
+	return util.PrintOutput(w, output, changes, []string{"KIND", "NAMESPACE", "NAME", "OWNERS", "SKIPPED"}, rows)
+}
@@ -0,0 +1,465 @@
+/*
+Copyright 2020 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ownermigrate converts ownership of objects between the owner labels of pkg/owner,
+// the owner annotation of pkg/multiowner and native Kubernetes ownerReferences.
+package ownermigrate
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"k8c.io/utils/pkg/multiowner"
+	"k8c.io/utils/pkg/owner"
+	"k8c.io/utils/pkg/util"
+)
+
+// Encoding describes how owners are recorded on an object.
+type Encoding string
+
+const (
+	// EncodingLabels are the owner.kubermatic.io/* labels of the owner package, holding a single owner.
+	EncodingLabels Encoding = "labels"
+	// EncodingAnnotation is the kubermatic.io/owner annotation of the multiowner package.
+	EncodingAnnotation Encoding = "annotation"
+	// EncodingOwnerReferences are the native metadata.ownerReferences.
+	// They can only reference owners in the same namespace or cluster-scoped owners.
+	EncodingOwnerReferences Encoding = "ownerReferences"
+)
+
+// Encodings lists all supported encodings.
+var Encodings = []Encoding{EncodingLabels, EncodingAnnotation, EncodingOwnerReferences}
+
+// ParseEncoding parses the name of an encoding.
+func ParseEncoding(s string) (Encoding, error) {
+	for _, e := range Encodings {
+		if string(e) == s {
+			return e, nil
+		}
+	}
+	return "", fmt.Errorf("unknown encoding %q, expected one of %v", s, Encodings)
+}
+
+// Change is the migration of a single object.
+type Change struct {
+	Object util.ObjectReference `json:"object"`
+	// Owners are the migrated owners.
+	Owners []util.ObjectReference `json:"owners"`
+	// Skipped explains, why the object was not migrated.
+	Skipped string `json:"skipped,omitempty"`
+
+	obj runtime.Object
+}
+
+// Migrator converts ownership of objects from one encoding to another.
+type Migrator struct {
+	Client client.Client
+	Mapper meta.RESTMapper
+	Scheme *runtime.Scheme
+	Log    logr.Logger
+
+	From, To Encoding
+	// Keep keeps the owners in the From encoding, e.g. while consumers of both encodings are still running.
+	Keep bool
+	// DryRun sends updates as dry-run requests.
+	DryRun bool
+	// OwnerOptions are used to write owner labels, e.g. owner.WithHashedLabels.
+	OwnerOptions []owner.Option
+}
+
+// ownerInfo is an owner read from an object.
+type ownerInfo struct {
+	ref util.ObjectReference
+	uid types.UID
+}
+
+// Migrate migrates all objects of the given types, which have owners in the From encoding.
+//
+// Only changed or skipped objects are returned, so migrating again is a no-op.
+// Objects are skipped, if their owners cannot be expressed in the To encoding,
+// e.g. multiple owners as labels or owners in other namespaces as ownerReferences,
+// or if the type of an owner has no REST mapping.
+func (m *Migrator) Migrate(ctx context.Context, objTypes []runtime.Object, options ...client.ListOption) ([]Change, error) {
+	if m.From == m.To {
+		return nil, fmt.Errorf("cannot migrate from %s to %s", m.From, m.To)
+	}
+
+	var changes []Change
+	for _, objType := range objTypes {
+		objs, err := util.ListObjects(ctx, m.Client, m.Scheme, []runtime.Object{objType}, options...)
+		if err != nil {
+			return changes, err
+		}
+
+		for _, obj := range objs {
+			change, changed, err := m.migrate(ctx, obj)
+			if err != nil {
+				return changes, fmt.Errorf("migrating %s: %w", util.ToObjectReference(obj, m.Scheme), err)
+			}
+			if !changed && change.Skipped == "" {
+				continue
+			}
+			if changed {
+				if err := m.update(ctx, obj); err != nil {
+					return changes, fmt.Errorf("updating %s: %w", change.Object, err)
+				}
+				m.Log.Info("migrated owners", "object", change.Object.String(), "dryRun", m.DryRun)
+			}
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+func (m *Migrator) update(ctx context.Context, obj runtime.Object) error {
+	var updateOpts []client.UpdateOption
+	if m.DryRun {
+		updateOpts = append(updateOpts, client.DryRunAll)
+	}
+	return m.Client.Update(ctx, obj, updateOpts...)
+}
+
+// migrate converts the owners of obj in place.
+func (m *Migrator) migrate(ctx context.Context, obj runtime.Object) (change Change, changed bool, err error) {
+	change.Object = util.ToObjectReference(obj, m.Scheme)
+	change.obj = obj
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return change, false, err
+	}
+
+	owners, err := m.read(accessor, m.From)
+	if reason, ok := unmappedOwner(err); ok {
+		change.Skipped = reason
+		return change, false, nil
+	}
+	if err != nil {
+		return change, false, err
+	}
+	if len(owners) == 0 {
+		return change, false, nil
+	}
+	for _, o := range owners {
+		change.Owners = append(change.Owners, o.ref)
+	}
+
+	original := obj.DeepCopyObject()
+	skipped, err := m.write(ctx, obj, accessor, owners)
+	if reason, ok := unmappedOwner(err); ok {
+		skipped, err = reason, nil
+	}
+	if err != nil || skipped != "" {
+		change.Skipped = skipped
+		// write may have been interrupted half way.
+		change.obj = original
+		return change, false, err
+	}
+	if !m.Keep {
+		if err := m.remove(obj, accessor, m.From, owners); err != nil {
+			return change, false, err
+		}
+	}
+	return change, !equality.Semantic.DeepEqual(original, obj), nil
+}
+
+// read returns the owners recorded in the given encoding.
+func (m *Migrator) read(accessor metav1.Object, enc Encoding) ([]ownerInfo, error) {
+	switch enc {
+	case EncodingLabels:
+		ref, owned, err := owner.GetOwnerReference(accessor)
+		if err != nil || !owned {
+			return nil, err
+		}
+		uid, _ := owner.GetOwnerUID(accessor)
+		return []ownerInfo{{ref: ref, uid: uid}}, nil
+
+	case EncodingAnnotation:
+		entries, err := multiowner.GetOwners(accessor)
+		if err != nil {
+			return nil, err
+		}
+		var owners []ownerInfo
+		for _, entry := range entries {
+			owners = append(owners, ownerInfo{ref: entry.ObjectReference, uid: entry.UID})
+		}
+		return owners, nil
+
+	case EncodingOwnerReferences:
+		var owners []ownerInfo
+		for _, ownerRef := range accessor.GetOwnerReferences() {
+			gv, err := schema.ParseGroupVersion(ownerRef.APIVersion)
+			if err != nil {
+				return nil, fmt.Errorf("parsing owner reference %s: %w", ownerRef.Name, err)
+			}
+			ref := util.ObjectReference{
+				Name:  ownerRef.Name,
+				Group: gv.Group,
+				Kind:  ownerRef.Kind,
+			}
+			namespaced, err := m.namespaced(ref)
+			if err != nil {
+				return nil, err
+			}
+			if namespaced {
+				// native owners always live in the namespace of the object.
+				ref.Namespace = accessor.GetNamespace()
+			}
+			owners = append(owners, ownerInfo{ref: ref, uid: ownerRef.UID})
+		}
+		return owners, nil
+	}
+	return nil, fmt.Errorf("unknown encoding %q", enc)
+}
+
+// write adds the owners in the To encoding.
+// A non-empty skip reason is returned, if the owners cannot be expressed in the To encoding.
+func (m *Migrator) write(ctx context.Context, obj runtime.Object, accessor metav1.Object, owners []ownerInfo) (skipped string, err error) {
+	switch m.To {
+	case EncodingLabels:
+		if len(owners) > 1 {
+			return fmt.Sprintf("%d owners cannot be encoded as owner labels", len(owners)), nil
+		}
+		ownerObj, err := m.ownerObject(owners[0])
+		if err != nil {
+			return "", err
+		}
+		if _, err := owner.SetOwnerReference(ownerObj, obj, m.Scheme, m.OwnerOptions...); err != nil {
+			if owner.IsOwnershipConflict(err) || owner.IsOwnerUIDMismatch(err) {
+				return err.Error(), nil
+			}
+			return "", err
+		}
+		return "", nil
+
+	case EncodingAnnotation:
+		multiObj, ok := obj.(interface {
+			runtime.Object
+			metav1.Object
+		})
+		if !ok {
+			return "", fmt.Errorf("%T is not a metav1.Object", obj)
+		}
+		for _, o := range owners {
+			ownerObj, err := m.ownerObject(o)
+			if err != nil {
+				return "", err
+			}
+			if _, err := multiowner.InsertOwnerReference(ownerObj, multiObj, m.Scheme); err != nil {
+				return "", err
+			}
+		}
+		return "", nil
+
+	case EncodingOwnerReferences:
+		ownerRefs := accessor.GetOwnerReferences()
+		for _, o := range owners {
+			namespaced, err := m.namespaced(o.ref)
+			if err != nil {
+				return "", err
+			}
+			if namespaced && o.ref.Namespace != accessor.GetNamespace() {
+				return fmt.Sprintf("owner %s is not in the namespace of the object", o.ref), nil
+			}
+			ownerObj, err := m.ownerObject(o)
+			if err != nil {
+				return "", err
+			}
+			// native owner references need the current UID of the owner, a recorded one may belong to a previous owner.
+			err = m.Client.Get(ctx, client.ObjectKey{Name: ownerObj.GetName(), Namespace: ownerObj.GetNamespace()}, ownerObj)
+			if errors.IsNotFound(err) {
+				return fmt.Sprintf("owner %s not found", o.ref), nil
+			}
+			if err != nil {
+				return "", fmt.Errorf("getting owner %s: %w", o.ref, err)
+			}
+			if !hasOwnerReference(ownerRefs, ownerObj.GetUID()) {
+				ownerRefs = append(ownerRefs, metav1.OwnerReference{
+					APIVersion: ownerObj.GetAPIVersion(),
+					Kind:       ownerObj.GetKind(),
+					Name:       ownerObj.GetName(),
+					UID:        ownerObj.GetUID(),
+				})
+			}
+		}
+		accessor.SetOwnerReferences(ownerRefs)
+		return "", nil
+	}
+	return "", fmt.Errorf("unknown encoding %q", m.To)
+}
+
+// remove removes the owners from the given encoding.
+func (m *Migrator) remove(obj runtime.Object, accessor metav1.Object, enc Encoding, owners []ownerInfo) error {
+	switch enc {
+	case EncodingLabels:
+		owner.RemoveOwnerReference(nil, obj)
+	case EncodingAnnotation:
+		for _, o := range owners {
+			if _, err := multiowner.DeleteObjectReference(o.ref, accessor); err != nil {
+				return err
+			}
+		}
+	case EncodingOwnerReferences:
+		var ownerRefs []metav1.OwnerReference
+		for _, ownerRef := range accessor.GetOwnerReferences() {
+			migrated := false
+			for _, o := range owners {
+				migrated = migrated || o.uid == ownerRef.UID
+			}
+			if !migrated {
+				ownerRefs = append(ownerRefs, ownerRef)
+			}
+		}
+		accessor.SetOwnerReferences(ownerRefs)
+	default:
+		return fmt.Errorf("unknown encoding %q", enc)
+	}
+	return nil
+}
+
+// ownerObject returns an owner object for the given reference, which can be passed to the owner packages.
+func (m *Migrator) ownerObject(o ownerInfo) (*unstructured.Unstructured, error) {
+	mapping, err := m.restMapping(o.ref)
+	if err != nil {
+		return nil, err
+	}
+	ownerObj := &unstructured.Unstructured{}
+	ownerObj.SetGroupVersionKind(mapping.GroupVersionKind)
+	ownerObj.SetName(o.ref.Name)
+	if mapping.Scope.Name() != meta.RESTScopeNameRoot {
+		ownerObj.SetNamespace(o.ref.Namespace)
+	}
+	ownerObj.SetUID(o.uid)
+	return ownerObj, nil
+}
+
+func (m *Migrator) namespaced(ref util.ObjectReference) (bool, error) {
+	mapping, err := m.restMapping(ref)
+	if err != nil {
+		return false, err
+	}
+	return mapping.Scope.Name() != meta.RESTScopeNameRoot, nil
+}
+
+// unmappedOwnerError is returned for owners, whose type has no REST mapping.
+type unmappedOwnerError struct {
+	owner util.ObjectReference
+	err   error
+}
+
+func (e *unmappedOwnerError) Error() string {
+	return fmt.Sprintf("owner %s has no REST mapping: %v", e.owner, e.err)
+}
+
+func (e *unmappedOwnerError) Unwrap() error {
+	return e.err
+}
+
+func (m *Migrator) restMapping(ref util.ObjectReference) (*meta.RESTMapping, error) {
+	mapping, err := m.Mapper.RESTMapping(schema.GroupKind{Group: ref.Group, Kind: ref.Kind})
+	if meta.IsNoMatchError(err) {
+		return nil, &unmappedOwnerError{owner: ref, err: err}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting REST mapping for owner %s: %w", ref, err)
+	}
+	return mapping, nil
+}
+
+// unmappedOwner returns the skip reason, if err is caused by an owner type without REST mapping,
+// e.g. because its CRD was removed. Such objects are skipped, so the other objects can still be migrated.
+func unmappedOwner(err error) (reason string, ok bool) {
+	var unmapped *unmappedOwnerError
+	if goerrors.As(err, &unmapped) {
+		return unmapped.Error(), true
+	}
+	return "", false
+}
+
+func hasOwnerReference(ownerRefs []metav1.OwnerReference, uid types.UID) bool {
+	for _, ownerRef := range ownerRefs {
+		if ownerRef.UID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify reads the migrated objects again, and checks that all owners are recorded in the To encoding,
+// and unless Keep is set, removed from the From encoding.
+func (m *Migrator) Verify(ctx context.Context, changes []Change) error {
+	var failures []string
+	for _, change := range changes {
+		if change.Skipped != "" {
+			continue
+		}
+		// read into an empty object, so no stale fields are kept.
+		obj, err := util.NewObject(change.obj, m.Scheme)
+		if err != nil {
+			return err
+		}
+		key := client.ObjectKey{Name: change.Object.Name, Namespace: change.Object.Namespace}
+		if err := m.Client.Get(ctx, key, obj); err != nil {
+			return fmt.Errorf("getting %s: %w", change.Object, err)
+		}
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+
+		to, err := m.read(accessor, m.To)
+		if err != nil {
+			return fmt.Errorf("reading owners of %s: %w", change.Object, err)
+		}
+		from, err := m.read(accessor, m.From)
+		if err != nil {
+			return fmt.Errorf("reading owners of %s: %w", change.Object, err)
+		}
+		for _, ref := range change.Owners {
+			if !containsOwner(to, ref) {
+				failures = append(failures, fmt.Sprintf("%s: owner %s missing in %s", change.Object, ref, m.To))
+			}
+			if !m.Keep && containsOwner(from, ref) {
+				failures = append(failures, fmt.Sprintf("%s: owner %s still in %s", change.Object, ref, m.From))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("verification failed:\n%s", strings.Join(failures, "\n"))
+	}
+	return nil
+}
+
+func containsOwner(owners []ownerInfo, ref util.ObjectReference) bool {
+	for _, o := range owners {
+		if o.ref == ref {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2020 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ownermigrate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"k8c.io/utils/pkg/multiowner"
+	"k8c.io/utils/pkg/owner"
+	"k8c.io/utils/pkg/testutil"
+	"k8c.io/utils/pkg/util"
+)
+
+var (
+	testScheme     = runtime.NewScheme()
+	testRESTMapper = meta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion})
+)
+
+func init() {
+	// setup scheme for all tests
+	utilruntime.Must(corev1.AddToScheme(testScheme))
+
+	testRESTMapper.Add(corev1.SchemeGroupVersion.WithKind("Namespace"), meta.RESTScopeRoot)
+	testRESTMapper.Add(corev1.SchemeGroupVersion.WithKind("Secret"), meta.RESTScopeNamespace)
+	testRESTMapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+}
+
+func TestMigrator(t *testing.T) {
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "default", UID: "secret-uid"}}
+	otherSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "other", UID: "other-uid"}}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", UID: "ns-uid"}}
+
+	newConfigMap := func(name string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	}
+
+	labelOwned := newConfigMap("label-owned")
+	_, err := owner.SetOwnerReference(secret, labelOwned, testScheme)
+	require.NoError(t, err)
+
+	annotationOwned := newConfigMap("annotation-owned")
+	_, err = multiowner.InsertOwnerReference(secret, annotationOwned, testScheme)
+	require.NoError(t, err)
+	_, err = multiowner.InsertOwnerReference(ns, annotationOwned, testScheme)
+	require.NoError(t, err)
+
+	crossNamespace := newConfigMap("cross-namespace")
+	_, err = multiowner.InsertOwnerReference(otherSecret, crossNamespace, testScheme)
+	require.NoError(t, err)
+
+	unowned := newConfigMap("unowned")
+
+	newMigrator := func(t *testing.T, from, to Encoding) (*Migrator, client.Client) {
+		cl := fakeclient.NewFakeClientWithScheme(testScheme,
+			secret.DeepCopy(), otherSecret.DeepCopy(), ns.DeepCopy(),
+			labelOwned.DeepCopy(), annotationOwned.DeepCopy(), crossNamespace.DeepCopy(), unowned.DeepCopy())
+		return &Migrator{
+			Client: cl,
+			Mapper: testRESTMapper,
+			Scheme: testScheme,
+			Log:    testutil.NewLogger(t),
+			From:   from,
+			To:     to,
+		}, cl
+	}
+	objTypes := []runtime.Object{&corev1.ConfigMap{}}
+	ctx := context.Background()
+
+	getConfigMap := func(t *testing.T, cl client.Client, name string) *corev1.ConfigMap {
+		cm := &corev1.ConfigMap{}
+		require.NoError(t, cl.Get(ctx, client.ObjectKey{Name: name, Namespace: "default"}, cm))
+		return cm
+	}
+	secretRef := util.ToObjectReference(secret, testScheme)
+
+	t.Run("labels to annotation", func(t *testing.T) {
+		migrator, cl := newMigrator(t, EncodingLabels, EncodingAnnotation)
+		changes, err := migrator.Migrate(ctx, objTypes)
+		require.NoError(t, err)
+		require.Len(t, changes, 1)
+		assert.Equal(t, "label-owned", changes[0].Object.Name)
+		assert.Equal(t, []util.ObjectReference{secretRef}, changes[0].Owners)
+		require.NoError(t, migrator.Verify(ctx, changes))
+
+		cm := getConfigMap(t, cl, "label-owned")
+		assert.False(t, owner.IsOwned(cm))
+		refs, err := multiowner.GetOwnerReferences(cm)
+		require.NoError(t, err)
+		assert.Equal(t, []util.ObjectReference{secretRef}, refs)
+
+		// migrating again is a no-op.
+		changes, err = migrator.Migrate(ctx, objTypes)
+		require.NoError(t, err)
+		assert.Empty(t, changes)
+	})
+
+	t.Run("annotation to ownerReferences", func(t *testing.T) {
+		migrator, cl := newMigrator(t, EncodingAnnotation, EncodingOwnerReferences)
+		changes, err := migrator.Migrate(ctx, objTypes)
+		require.NoError(t, err)
+		require.Len(t, changes, 2)
+		assert.Equal(t, "annotation-owned", changes[0].Object.Name)
+		assert.Empty(t, changes[0].Skipped)
+		assert.Equal(t, "cross-namespace", changes[1].Object.Name)
+		assert.Equal(t, "owner Secret./other:owner is not in the namespace of the object", changes[1].Skipped)
+		require.NoError(t, migrator.Verify(ctx, changes))
+
+		cm := getConfigMap(t, cl, "annotation-owned")
+		owned, err := multiowner.IsOwned(cm)
+		require.NoError(t, err)
+		assert.False(t, owned)
+		assert.Equal(t, []metav1.OwnerReference{
+			{APIVersion: "v1", Kind: "Secret", Name: "owner", UID: "secret-uid"},
+			{APIVersion: "v1", Kind: "Namespace", Name: "default", UID: "ns-uid"},
+		}, cm.OwnerReferences)
+
+		// the skipped object is untouched.
+		cm = getConfigMap(t, cl, "cross-namespace")
+		owned, err = multiowner.IsOwned(cm)
+		require.NoError(t, err)
+		assert.True(t, owned)
+	})
+
+	t.Run("ownerReferences to labels", func(t *testing.T) {
+		migrator, cl := newMigrator(t, EncodingAnnotation, EncodingOwnerReferences)
+		_, err := migrator.Migrate(ctx, objTypes)
+		require.NoError(t, err)
+
+		migrator.From, migrator.To = EncodingOwnerReferences, EncodingLabels
+		changes, err := migrator.Migrate(ctx, objTypes)
+		require.NoError(t, err)
+		require.Len(t, changes, 1)
+		assert.Equal(t, "2 owners cannot be encoded as owner labels", changes[0].Skipped)
+
+		cm := getConfigMap(t, cl, "annotation-owned")
+		assert.Len(t, cm.OwnerReferences, 2)
+		assert.False(t, owner.IsOwned(cm))
+	})
+
+	t.Run("keep", func(t *testing.T) {
+		migrator, cl := newMigrator(t, EncodingLabels, EncodingAnnotation)
+		migrator.Keep = true
+		changes, err := migrator.Migrate(ctx, objTypes)
+		require.NoError(t, err)
+		require.Len(t, changes, 1)
+		require.NoError(t, migrator.Verify(ctx, changes))
+
+		cm := getConfigMap(t, cl, "label-owned")
+		assert.True(t, owner.IsOwned(cm))
+		owned, err := multiowner.IsOwned(cm)
+		require.NoError(t, err)
+		assert.True(t, owned)
+	})
+
+	t.Run("dry-run", func(t *testing.T) {
+		migrator, cl := newMigrator(t, EncodingLabels, EncodingAnnotation)
+		migrator.DryRun = true
+		changes, err := migrator.Migrate(ctx, objTypes)
+		require.NoError(t, err)
+		require.Len(t, changes, 1)
+
+		cm := getConfigMap(t, cl, "label-owned")
+		assert.True(t, owner.IsOwned(cm))
+		owned, err := multiowner.IsOwned(cm)
+		require.NoError(t, err)
+		assert.False(t, owned)
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		migrator, cl := newMigrator(t, EncodingLabels, EncodingAnnotation)
+		changes, err := migrator.Migrate(ctx, objTypes)
+		require.NoError(t, err)
+
+		cm := getConfigMap(t, cl, "label-owned")
+		_, err = multiowner.DeleteObjectReference(secretRef, cm)
+		require.NoError(t, err)
+		require.NoError(t, cl.Update(ctx, cm))
+
+		err = migrator.Verify(ctx, changes)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "owner Secret./default:owner missing in annotation")
+	})
+
+	t.Run("owner type without REST mapping", func(t *testing.T) {
+		migrator, cl := newMigrator(t, EncodingAnnotation, EncodingLabels)
+		// e.g. the CRD of the owner was removed.
+		widget := &unstructured.Unstructured{}
+		widget.SetGroupVersionKind(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"})
+		widget.SetName("widget")
+		widget.SetNamespace("default")
+		widgetOwned := newConfigMap("widget-owned")
+		_, err := multiowner.InsertOwnerReference(widget, widgetOwned, testScheme)
+		require.NoError(t, err)
+		require.NoError(t, cl.Create(ctx, widgetOwned))
+
+		changes, err := migrator.Migrate(ctx, objTypes)
+		require.NoError(t, err)
+		skipped := map[string]string{}
+		for _, change := range changes {
+			skipped[change.Object.Name] = change.Skipped
+		}
+		assert.Equal(t, "", skipped["cross-namespace"], "other objects are still migrated")
+		assert.Contains(t, skipped["widget-owned"], "owner Widget.example.com/default:widget has no REST mapping")
+	})
+
+	t.Run("same encoding", func(t *testing.T) {
+		migrator, _ := newMigrator(t, EncodingLabels, EncodingLabels)
+		_, err := migrator.Migrate(ctx, objTypes)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testutil
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// InterceptingClient wraps a client, so tests can intercept single operations,
+// e.g. to record calls or to emulate API server behavior, which the fake client lacks.
+// Operations without a hook are passed to the wrapped client, hooks get the wrapped client to continue with.
+type InterceptingClient struct {
+	client.Client
+
+	GetFunc    func(ctx context.Context, cl client.Client, key client.ObjectKey, obj runtime.Object) error
+	ListFunc   func(ctx context.Context, cl client.Client, list runtime.Object, opts ...client.ListOption) error
+	CreateFunc func(ctx context.Context, cl client.Client, obj runtime.Object, opts ...client.CreateOption) error
+	UpdateFunc func(ctx context.Context, cl client.Client, obj runtime.Object, opts ...client.UpdateOption) error
+	PatchFunc  func(ctx context.Context, cl client.Client, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error
+	DeleteFunc func(ctx context.Context, cl client.Client, obj runtime.Object, opts ...client.DeleteOption) error
+}
+
+var _ client.Client = (*InterceptingClient)(nil)
+
+func (c *InterceptingClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	if c.GetFunc != nil {
+		return c.GetFunc(ctx, c.Client, key, obj)
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c *InterceptingClient) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	if c.ListFunc != nil {
+		return c.ListFunc(ctx, c.Client, list, opts...)
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *InterceptingClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	if c.CreateFunc != nil {
+		return c.CreateFunc(ctx, c.Client, obj, opts...)
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *InterceptingClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	if c.UpdateFunc != nil {
+		return c.UpdateFunc(ctx, c.Client, obj, opts...)
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *InterceptingClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if c.PatchFunc != nil {
+		return c.PatchFunc(ctx, c.Client, obj, patch, opts...)
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *InterceptingClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	if c.DeleteFunc != nil {
+		return c.DeleteFunc(ctx, c.Client, obj, opts...)
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+// DeleteWithPreconditions deletes obj and fails with a Conflict like the API server,
+// if the UID or resourceVersion preconditions do not match the live object, which the fake client ignores.
+// It can be used as InterceptingClient.DeleteFunc.
+func DeleteWithPreconditions(ctx context.Context, cl client.Client, obj runtime.Object, opts ...client.DeleteOption) error {
+	deleteOpts := &client.DeleteOptions{}
+	deleteOpts.ApplyOptions(opts)
+	if p := deleteOpts.Preconditions; p != nil {
+		key, err := client.ObjectKeyFromObject(obj)
+		if err != nil {
+			return err
+		}
+		live := obj.DeepCopyObject()
+		if err := cl.Get(ctx, key, live); err != nil {
+			return err
+		}
+		accessor, err := meta.Accessor(live)
+		if err != nil {
+			return err
+		}
+		if p.UID != nil && *p.UID != accessor.GetUID() {
+			return errors.NewConflict(schema.GroupResource{}, key.Name, fmt.Errorf("precondition failed: UID in precondition: %v, UID in object meta: %v", *p.UID, accessor.GetUID()))
+		}
+		if p.ResourceVersion != nil && *p.ResourceVersion != accessor.GetResourceVersion() {
+			return errors.NewConflict(schema.GroupResource{}, key.Name, fmt.Errorf("precondition failed: ResourceVersion in precondition: %v, ResourceVersion in object meta: %v", *p.ResourceVersion, accessor.GetResourceVersion()))
+		}
+	}
+	return cl.Delete(ctx, obj, opts...)
+}
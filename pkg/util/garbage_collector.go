@@ -0,0 +1,66 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// NewGarbageCollectorBuilder returns a controller builder for a garbage collector of owners of ownerType,
+// named after name and the owner type.
+// Events of ownedTypes are mapped to their owners through ownerHandler,
+// so objects of owners, which are missing since startup, are collected as well.
+func NewGarbageCollectorBuilder(mgr ctrl.Manager, scheme *runtime.Scheme, name string, ownerType runtime.Object, ownedTypes []runtime.Object, ownerHandler handler.EventHandler) (*builder.Builder, error) {
+	ownerGVK, err := apiutil.GVKForObject(ownerType, scheme)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get GVK for %T: %w", ownerType, err)
+	}
+
+	b := ctrl.NewControllerManagedBy(mgr).
+		Named(name + "-" + strings.ToLower(ownerGVK.GroupKind().String())).
+		For(ownerType)
+	for _, ownedType := range ownedTypes {
+		b = b.Watches(&source.Kind{Type: ownedType}, ownerHandler)
+	}
+	return b, nil
+}
+
+// GetOwner reads the requested owner of a garbage collector into ownerObj.
+// A NotFound error of cl is confirmed with an uncached read through apiReader, to not act on a stale cache.
+// apiReader defaults to cl.
+func GetOwner(ctx context.Context, cl client.Client, apiReader client.Reader, req reconcile.Request, ownerObj runtime.Object) error {
+	err := cl.Get(ctx, req.NamespacedName, ownerObj)
+	if !errors.IsNotFound(err) {
+		return err
+	}
+	if apiReader == nil {
+		apiReader = cl
+	}
+	return apiReader.Get(ctx, req.NamespacedName, ownerObj)
+}
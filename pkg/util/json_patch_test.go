@@ -0,0 +1,42 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestNewJSONPatch(t *testing.T) {
+	patch, err := NewJSONPatch(
+		JSONPatchOperation{Op: "test", Path: "/metadata/resourceVersion", Value: "1"},
+		JSONPatchOperation{Op: "add", Path: "/metadata/labels", Value: map[string]string{}},
+		JSONPatchOperation{Op: "remove", Path: "/metadata/annotations/" + EscapeJSONPointer("kubermatic.io/owner~1")},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, types.JSONPatchType, patch.Type())
+	data, err := patch.Data(nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"op": "test", "path": "/metadata/resourceVersion", "value": "1"},
+		{"op": "add", "path": "/metadata/labels", "value": {}},
+		{"op": "remove", "path": "/metadata/annotations/kubermatic.io~1owner~01"}
+	]`, string(data))
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/yaml"
+)
+
+// Output formats of commands using KubeFlags.
+const (
+	OutputTable = "table"
+	OutputYAML  = "yaml"
+)
+
+// KubeFlags are the common flags of commands working on objects of arbitrary types in a cluster.
+type KubeFlags struct {
+	Output        string
+	AllNamespaces bool
+	ConfigFlags   *genericclioptions.ConfigFlags
+}
+
+func NewKubeFlags() *KubeFlags {
+	return &KubeFlags{
+		Output:      OutputTable,
+		ConfigFlags: genericclioptions.NewConfigFlags(true),
+	}
+}
+
+// AddFlags adds the kubeconfig flags, --output and --all-namespaces to cmd.
+func (f *KubeFlags) AddFlags(cmd *cobra.Command, allNamespacesUsage string) {
+	f.ConfigFlags.AddFlags(cmd.Flags())
+	cmd.Flags().StringVarP(&f.Output, "output", "o", f.Output, "output format, one of: table, yaml")
+	cmd.Flags().BoolVarP(&f.AllNamespaces, "all-namespaces", "A", f.AllNamespaces, allNamespacesUsage)
+}
+
+// Validate checks the output format.
+func (f *KubeFlags) Validate() error {
+	if f.Output != OutputTable && f.Output != OutputYAML {
+		return fmt.Errorf("unknown output format %q", f.Output)
+	}
+	return nil
+}
+
+// KubeClients are the clients for objects of types unknown to the scheme.
+type KubeClients struct {
+	Client    client.Client
+	Mapper    meta.RESTMapper
+	Scheme    *runtime.Scheme
+	Discovery discovery.DiscoveryInterface
+}
+
+// NewClients builds the clients from the kubeconfig flags.
+// The scheme is empty, so objects are read as unstructured objects.
+func (f *KubeFlags) NewClients() (*KubeClients, error) {
+	cfg, err := f.ConfigFlags.ToRESTConfig()
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	mapper, err := apiutil.NewDynamicRESTMapper(cfg, apiutil.WithLazyDiscovery)
+	if err != nil {
+		return nil, fmt.Errorf("rest mapper: %w", err)
+	}
+	scheme := runtime.NewScheme()
+	k8sClient, err := client.New(cfg, client.Options{Scheme: scheme, Mapper: mapper})
+	if err != nil {
+		return nil, fmt.Errorf("client: %w", err)
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("discovery client: %w", err)
+	}
+	return &KubeClients{
+		Client:    k8sClient,
+		Mapper:    mapper,
+		Scheme:    scheme,
+		Discovery: discoveryClient,
+	}, nil
+}
+
+// ListOptions restricts lists to the namespace of the kubeconfig, unless --all-namespaces is set.
+func (f *KubeFlags) ListOptions() ([]client.ListOption, error) {
+	if f.AllNamespaces {
+		return nil, nil
+	}
+	namespace, _, err := f.ConfigFlags.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return nil, fmt.Errorf("namespace: %w", err)
+	}
+	return []client.ListOption{client.InNamespace(namespace)}, nil
+}
+
+// PrintOutput writes v as YAML, or the given rows as table for OutputTable.
+func PrintOutput(w io.Writer, output string, v interface{}, header []string, rows [][]string) error {
+	if output == OutputYAML {
+		b, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		_, _ = fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintOutput(t *testing.T) {
+	type row struct {
+		Name string `json:"name"`
+	}
+	rows := []row{{Name: "a"}, {Name: "bb"}}
+
+	var buf bytes.Buffer
+	require.NoError(t, PrintOutput(&buf, OutputTable, rows, []string{"NAME", "VALUE"}, [][]string{{"a", "1"}, {"bb", "2"}}))
+	assert.Equal(t, "NAME   VALUE\na      1\nbb     2\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, PrintOutput(&buf, OutputYAML, rows, nil, nil))
+	assert.Equal(t, "- name: a\n- name: bb\n", buf.String())
+}
+
+func TestKubeFlags_Validate(t *testing.T) {
+	f := NewKubeFlags()
+	assert.NoError(t, f.Validate())
+	f.Output = "json"
+	assert.Error(t, f.Validate())
+}
@@ -24,6 +24,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
@@ -72,7 +73,31 @@ func ToObjectReference(object runtime.Object, scheme *runtime.Scheme) ObjectRefe
 	}
 }
 
+// NewObject returns an empty object of the type of objType.
+//
+// *unstructured.Unstructured type hints are returned as *unstructured.Unstructured with the same GVK,
+// so types unknown to the scheme are supported as well.
+func NewObject(objType runtime.Object, scheme *runtime.Scheme) (runtime.Object, error) {
+	gvk, err := apiutil.GVKForObject(objType, scheme)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get GVK for %T: %w", objType, err)
+	}
+	if _, isUnstructured := objType.(*unstructured.Unstructured); isUnstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gvk)
+		return obj, nil
+	}
+	obj, err := scheme.New(gvk)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create %v: %w", gvk, err)
+	}
+	return obj, nil
+}
+
 // ListObjects lists all object of given types adhering to additional ListOptions
+//
+// *unstructured.Unstructured type hints are listed as *unstructured.UnstructuredList,
+// so types unknown to the scheme can be listed as well.
 func ListObjects(ctx context.Context, cl client.Client, scheme *runtime.Scheme, listTypes []runtime.Object, options ...client.ListOption) ([]runtime.Object, error) {
 	objs := make([]runtime.Object, 0)
 	for _, objType := range listTypes {
@@ -80,15 +105,23 @@ func ListObjects(ctx context.Context, cl client.Client, scheme *runtime.Scheme,
 		if err != nil {
 			return nil, fmt.Errorf("cannot get GVK for %T: %w", objType, err)
 		}
-		if _, isList := objType.(metav1.ListInterface); isList {
+		u, isUnstructured := objType.(*unstructured.Unstructured)
+		if _, isList := objType.(metav1.ListInterface); isList && (!isUnstructured || u.IsList()) {
 			return nil, fmt.Errorf("should not pass ListInterface as listTypes, got %v", gvk)
 		}
 
 		ListGVK := gvk
 		ListGVK.Kind = gvk.Kind + "List"
-		ListObjType, err := scheme.New(ListGVK)
-		if err != nil {
-			return nil, fmt.Errorf("cannot make a list out of a types: %v", gvk)
+		var ListObjType runtime.Object
+		if isUnstructured {
+			lst := &unstructured.UnstructuredList{}
+			lst.SetGroupVersionKind(ListGVK)
+			ListObjType = lst
+		} else {
+			ListObjType, err = scheme.New(ListGVK)
+			if err != nil {
+				return nil, fmt.Errorf("cannot make a list out of a types: %v", gvk)
+			}
 		}
 		if !meta.IsListType(ListObjType) {
 			return nil, fmt.Errorf("cannot make a list out of a types: %v", gvk)
@@ -108,6 +141,8 @@ func ListObjects(ctx context.Context, cl client.Client, scheme *runtime.Scheme,
 }
 
 // Delete deletes all object of given types adhering to additional ListOptions
+//
+// Like in ListObjects, *unstructured.Unstructured type hints support types unknown to the scheme.
 func DeleteObjects(ctx context.Context, cl client.Client, scheme *runtime.Scheme, listTypes []runtime.Object, options ...client.ListOption) (cleanedUp bool, err error) {
 	objs, err := ListObjects(ctx, cl, scheme, listTypes, options...)
 	if err != nil {
@@ -24,7 +24,9 @@ import (
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
@@ -80,6 +82,14 @@ func TestListObjects(t *testing.T) {
 			types:    []runtime.Object{&corev1.ConfigMap{}, &corev1.Secret{}},
 			wantsObj: []runtime.Object{cmA, cmB, secA, secB},
 		},
+		"unstructured": {
+			types: []runtime.Object{func() runtime.Object {
+				obj := &unstructured.Unstructured{}
+				obj.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("ConfigMap"))
+				return obj
+			}()},
+			wantsObj: []runtime.Object{cmA, cmB},
+		},
 		"ns-only": {
 			types:    []runtime.Object{&corev1.ConfigMap{}, &corev1.Secret{}},
 			wantsObj: []runtime.Object{cmA, secA},
@@ -105,3 +115,25 @@ func TestListObjects(t *testing.T) {
 		})
 	}
 }
+
+func TestNewObject(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	obj, err := NewObject(&corev1.ConfigMap{Data: map[string]string{"a": "b"}}, scheme)
+	require.NoError(t, err)
+	assert.Equal(t, &corev1.ConfigMap{}, obj)
+
+	widgetType := &unstructured.Unstructured{}
+	widgetType.SetGroupVersionKind(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"})
+	widgetType.SetName("widget")
+	obj, err = NewObject(widgetType, scheme)
+	require.NoError(t, err)
+	if assert.IsType(t, &unstructured.Unstructured{}, obj) {
+		assert.Equal(t, widgetType.GroupVersionKind(), obj.GetObjectKind().GroupVersionKind())
+		assert.Empty(t, obj.(*unstructured.Unstructured).GetName())
+	}
+
+	_, err = NewObject(&corev1.Namespace{}, runtime.NewScheme())
+	assert.Error(t, err, "type unknown to the scheme")
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2020 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// NativeOwnerReference returns a metav1.OwnerReference pointing to owner.
+// The owner must have a UID, so it must have been created already.
+func NativeOwnerReference(owner runtime.Object, scheme *runtime.Scheme, controller, blockOwnerDeletion bool) (metav1.OwnerReference, error) {
+	accessor, err := meta.Accessor(owner)
+	if err != nil {
+		return metav1.OwnerReference{}, fmt.Errorf("cannot get accessor for %T: %w", owner, err)
+	}
+	if accessor.GetUID() == "" {
+		return metav1.OwnerReference{}, fmt.Errorf("owner %s has no UID", ToObjectReference(owner, scheme))
+	}
+	gvk, err := apiutil.GVKForObject(owner, scheme)
+	if err != nil {
+		return metav1.OwnerReference{}, fmt.Errorf("cannot get GVK for %T: %w", owner, err)
+	}
+	return metav1.OwnerReference{
+		APIVersion:         gvk.GroupVersion().String(),
+		Kind:               gvk.Kind,
+		Name:               accessor.GetName(),
+		UID:                accessor.GetUID(),
+		Controller:         &controller,
+		BlockOwnerDeletion: &blockOwnerDeletion,
+	}, nil
+}
+
+// UpsertOwnerReference adds ref to the ownerReferences of object, or updates an existing reference to the same owner.
+// It returns a controllerutil.AlreadyOwnedError, if ref is a controller reference and object has another controller.
+func UpsertOwnerReference(object metav1.Object, ref metav1.OwnerReference) (changed bool, err error) {
+	refs := object.GetOwnerReferences()
+	idx := -1
+	for i, r := range refs {
+		if sameOwner(r, ref) {
+			idx = i
+			continue
+		}
+		if isController(r) && isController(ref) {
+			return false, &controllerutil.AlreadyOwnedError{Object: object, Owner: r}
+		}
+	}
+
+	if idx == -1 {
+		object.SetOwnerReferences(append(refs, ref))
+		return true, nil
+	}
+	if equalOwnerReferences(refs[idx], ref) {
+		return false, nil
+	}
+	refs[idx] = ref
+	object.SetOwnerReferences(refs)
+	return true, nil
+}
+
+// SetNativeOwnerReference adds a metav1.OwnerReference to owner to object,
+// if owner is cluster-scoped or lives in the namespace of object, and has a UID.
+// ownerRef references owner, its namespace is empty for cluster-scoped owners.
+// Owners in other clusters must not be passed, as the Kubernetes garbage collector would delete object.
+func SetNativeOwnerReference(owner runtime.Object, object metav1.Object, ownerRef ObjectReference, scheme *runtime.Scheme, controller, blockOwnerDeletion bool) (changed bool, err error) {
+	if ownerRef.Namespace != "" && ownerRef.Namespace != object.GetNamespace() {
+		return false, nil
+	}
+	if ownerAccessor, err := meta.Accessor(owner); err != nil || ownerAccessor.GetUID() == "" {
+		return false, nil
+	}
+	ref, err := NativeOwnerReference(owner, scheme, controller, blockOwnerDeletion)
+	if err != nil {
+		return false, err
+	}
+	return UpsertOwnerReference(object, ref)
+}
+
+// RemoveNativeOwnerReference removes all ownerReferences of object pointing to the referenced owner.
+func RemoveNativeOwnerReference(object metav1.Object, owner ObjectReference) (changed bool) {
+	var refs []metav1.OwnerReference
+	for _, r := range object.GetOwnerReferences() {
+		gv, err := schema.ParseGroupVersion(r.APIVersion)
+		if err == nil && gv.Group == owner.Group && r.Kind == owner.Kind && r.Name == owner.Name {
+			changed = true
+			continue
+		}
+		refs = append(refs, r)
+	}
+	if changed {
+		object.SetOwnerReferences(refs)
+	}
+	return changed
+}
+
+// sameOwner compares group, kind and name, ignoring the version and UID, so references to recreated owners are updated.
+func sameOwner(a, b metav1.OwnerReference) bool {
+	aGV, err := schema.ParseGroupVersion(a.APIVersion)
+	if err != nil {
+		return false
+	}
+	bGV, err := schema.ParseGroupVersion(b.APIVersion)
+	if err != nil {
+		return false
+	}
+	return aGV.Group == bGV.Group && a.Kind == b.Kind && a.Name == b.Name
+}
+
+func isController(ref metav1.OwnerReference) bool {
+	return ref.Controller != nil && *ref.Controller
+}
+
+func equalOwnerReferences(a, b metav1.OwnerReference) bool {
+	return a.APIVersion == b.APIVersion && a.Kind == b.Kind && a.Name == b.Name && a.UID == b.UID &&
+		isController(a) == isController(b) &&
+		(a.BlockOwnerDeletion != nil && *a.BlockOwnerDeletion) == (b.BlockOwnerDeletion != nil && *b.BlockOwnerDeletion)
+}
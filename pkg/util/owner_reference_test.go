@@ -0,0 +1,111 @@
+/*
+Copyright 2020 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestNativeOwnerReference(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	ownerA := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default", UID: "a-uid"}}
+	ownerB := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "default", UID: "b-uid"}}
+	obj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"}}
+
+	_, err := NativeOwnerReference(&corev1.Secret{}, scheme, true, true)
+	assert.Error(t, err, "owner without UID")
+
+	refA, err := NativeOwnerReference(ownerA, scheme, true, true)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", refA.APIVersion)
+	assert.Equal(t, "Secret", refA.Kind)
+
+	changed, err := UpsertOwnerReference(obj, refA)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = UpsertOwnerReference(obj, refA)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// a recreated owner updates the existing reference.
+	recreated := ownerA.DeepCopy()
+	recreated.UID = "recreated-uid"
+	refRecreated, err := NativeOwnerReference(recreated, scheme, true, true)
+	require.NoError(t, err)
+	changed, err = UpsertOwnerReference(obj, refRecreated)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []metav1.OwnerReference{refRecreated}, obj.OwnerReferences)
+
+	// only one controller is allowed.
+	refB, err := NativeOwnerReference(ownerB, scheme, true, false)
+	require.NoError(t, err)
+	_, err = UpsertOwnerReference(obj, refB)
+	var alreadyOwned *controllerutil.AlreadyOwnedError
+	assert.True(t, errors.As(err, &alreadyOwned))
+
+	refB, err = NativeOwnerReference(ownerB, scheme, false, false)
+	require.NoError(t, err)
+	changed, err = UpsertOwnerReference(obj, refB)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Len(t, obj.OwnerReferences, 2)
+
+	assert.True(t, RemoveNativeOwnerReference(obj, ToObjectReference(ownerA, scheme)))
+	assert.False(t, RemoveNativeOwnerReference(obj, ToObjectReference(ownerA, scheme)))
+	assert.Equal(t, []metav1.OwnerReference{refB}, obj.OwnerReferences)
+}
+
+func TestSetNativeOwnerReference(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	owner := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "default", UID: "owner-uid"}}
+	ownerRef := ToObjectReference(owner, scheme)
+
+	obj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"}}
+	changed, err := SetNativeOwnerReference(owner, obj, ownerRef, scheme, true, false)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	if assert.Len(t, obj.OwnerReferences, 1) {
+		assert.Equal(t, owner.UID, obj.OwnerReferences[0].UID)
+	}
+
+	// cross-namespace owners cannot be referenced natively.
+	obj = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "other"}}
+	changed, err = SetNativeOwnerReference(owner, obj, ownerRef, scheme, true, false)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Empty(t, obj.OwnerReferences)
+
+	// neither can owners without UID.
+	obj = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"}}
+	changed, err = SetNativeOwnerReference(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "default"}}, obj, ownerRef, scheme, true, false)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Empty(t, obj.OwnerReferences)
+}
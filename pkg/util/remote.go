@@ -0,0 +1,81 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// ClusterLabel names the cluster of the owners of an object, for objects owned across clusters.
+// It tells owners with the same name in different clusters apart, when several clusters manage objects in one cluster.
+const ClusterLabel = "owner.kubermatic.io/cluster"
+
+// SetClusterLabel labels object with the name of the cluster of its owners.
+// It fails, if object is labeled with another cluster already.
+func SetClusterLabel(object metav1.Object, clusterName string) (changed bool, err error) {
+	labels := object.GetLabels()
+	current, ok := labels[ClusterLabel]
+	switch {
+	case ok && current == clusterName:
+		return false, nil
+	case ok && current != "":
+		return false, fmt.Errorf("%s/%s is owned from cluster %q, not %q", object.GetNamespace(), object.GetName(), current, clusterName)
+	}
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[ClusterLabel] = clusterName
+	object.SetLabels(labels)
+	return true, nil
+}
+
+// InCluster returns a predicate matching objects labeled with the given ClusterLabel.
+func InCluster(clusterName string) PredicateFn {
+	return func(obj runtime.Object) bool {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return false
+		}
+		return accessor.GetLabels()[ClusterLabel] == clusterName
+	}
+}
+
+// WatchRemote watches objects of objectType in another cluster, and passes their events to eventHandler.
+// Use owner.EnqueueRequestForOwner or multiowner.EnqueueRequestForOwner as eventHandler,
+// to reconcile owners in the cluster of the controller, when objects they own in the other cluster change.
+//
+// remoteCache is the cache of the other cluster, e.g. created with cache.New for its rest.Config.
+// It is not started by the controller, add it to the manager as well.
+//
+// If clusterName is not empty, only objects labeled with ClusterLabel=clusterName are watched,
+// so several clusters can manage objects in the other cluster, and several other clusters can feed one controller.
+// Label owned objects through the WithClusterName options of the owner and multiowner packages.
+func WatchRemote(c controller.Controller, remoteCache cache.Cache, objectType runtime.Object, eventHandler handler.EventHandler, clusterName string, prct ...predicate.Predicate) error {
+	if clusterName != "" {
+		prct = append([]predicate.Predicate{InCluster(clusterName)}, prct...)
+	}
+	return c.Watch(source.NewKindWithCache(objectType, remoteCache), eventHandler, prct...)
+}
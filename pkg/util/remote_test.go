@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The Kubermatic Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// watchRecorder records the arguments of Watch.
+type watchRecorder struct {
+	controller.Controller
+	src        source.Source
+	handler    handler.EventHandler
+	predicates []predicate.Predicate
+}
+
+func (w *watchRecorder) Watch(src source.Source, h handler.EventHandler, prct ...predicate.Predicate) error {
+	w.src, w.handler, w.predicates = src, h, prct
+	return nil
+}
+
+func TestClusterLabel(t *testing.T) {
+	obj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"}}
+	inA := InCluster("a")
+	assert.False(t, inA(obj))
+
+	changed, err := SetClusterLabel(obj, "a")
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "a", obj.Labels[ClusterLabel])
+	assert.True(t, inA(obj))
+
+	changed, err = SetClusterLabel(obj, "a")
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	_, err = SetClusterLabel(obj, "b")
+	assert.Error(t, err, "object of another cluster")
+	assert.Equal(t, "a", obj.Labels[ClusterLabel])
+	assert.False(t, InCluster("b")(obj))
+}
+
+func TestWatchRemote(t *testing.T) {
+	h := &handler.EnqueueRequestForObject{}
+	other := predicate.Funcs{}
+
+	c := &watchRecorder{}
+	require.NoError(t, WatchRemote(c, nil, &corev1.ConfigMap{}, h, "", other))
+	assert.NotNil(t, c.src)
+	assert.Equal(t, h, c.handler)
+	assert.Len(t, c.predicates, 1, "no cluster filter without cluster name")
+
+	c = &watchRecorder{}
+	require.NoError(t, WatchRemote(c, nil, &corev1.ConfigMap{}, h, "a", other))
+	require.Len(t, c.predicates, 2)
+	for obj, want := range map[runtime.Object]bool{
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{ClusterLabel: "a"}}}: true,
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{ClusterLabel: "b"}}}: false,
+		&corev1.ConfigMap{}: false,
+	} {
+		assert.Equal(t, want, c.predicates[0].Create(event.CreateEvent{Object: obj}))
+	}
+}